@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -69,6 +70,7 @@ func start() int {
 		MaxIdleConnections:    env.GetIntOrDefault("DATABASE_MAX_IDLE_CONNS", 5),
 		ConnectionMaxLifetime: env.GetDurationOrDefault("DATABASE_CONN_MAX_LIFETIME", time.Hour),
 		ConnectionMaxIdleTime: env.GetDurationOrDefault("DATABASE_CONN_MAX_IDLE_TIME", time.Hour),
+		LiteFSDir:             env.GetStringOrDefault("LITEFS_DIR", ""),
 	})
 
 	if err := db.Connect(); err != nil {
@@ -103,23 +105,29 @@ func start() int {
 	})
 
 	s := http.NewServer(http.NewServerOptions{
-		Database:    db,
-		Host:        env.GetStringOrDefault("HOST", ""),
-		Log:         log,
-		Metrics:     registry,
-		ObjectStore: objectStore,
-		Port:        env.GetIntOrDefault("PORT", 8080),
+		AdminPassword: env.GetStringOrDefault("ADMIN_PASSWORD", ""),
+		Database:      db,
+		Host:          env.GetStringOrDefault("HOST", ""),
+		Log:           log,
+		Metrics:       registry,
+		ObjectStore:   objectStore,
+		Port:          env.GetIntOrDefault("PORT", 8080),
 	})
 
 	runner := jobs.NewRunner(jobs.NewRunnerOptions{
-		Database:     db,
-		EmailSender:  emailSender,
-		JobLimit:     5,
-		Log:          log,
-		Metrics:      registry,
-		PollInterval: time.Second,
-		Queue:        db,
+		Database:         db,
+		DrainTimeout:     env.GetDurationOrDefault("JOBS_DRAIN_TIMEOUT", 4*time.Second),
+		EmailSender:      emailSender,
+		History:          env.GetBoolOrDefault("JOBS_HISTORY_ENABLED", false),
+		HistoryRetention: env.GetDurationOrDefault("JOBS_HISTORY_RETENTION", 30*24*time.Hour),
+		JobLimit:         5,
+		LeadSeparately:   true,
+		Log:              log,
+		Metrics:          registry,
+		PollInterval:     time.Second,
+		Queue:            db,
 	})
+	runner.Use(jobs.Honeybadger)
 
 	eg, ctx := errgroup.WithContext(ctx)
 
@@ -133,10 +141,23 @@ func start() int {
 	}
 
 	if env.GetBoolOrDefault("JOBS_ENABLED", true) {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Println("Error getting hostname:", err)
+			return 1
+		}
+		owner := hostname + ":" + strconv.Itoa(os.Getpid())
+
 		eg.Go(func() error {
 			runner.Start(ctx)
 			return nil
 		})
+
+		eg.Go(func() error {
+			// Only one instance at a time schedules jobs and quarantines unknown ones, while all instances run jobs
+			db.Lead(ctx, "jobs", owner, env.GetDurationOrDefault("JOBS_LEASE_DURATION", 15*time.Second), runner.Lead)
+			return nil
+		})
 	}
 
 	<-ctx.Done()
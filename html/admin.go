@@ -0,0 +1,175 @@
+package html
+
+import (
+	"net/url"
+	"strconv"
+
+	g "github.com/maragudk/gomponents"
+	c "github.com/maragudk/gomponents/components"
+	. "github.com/maragudk/gomponents/html"
+
+	"github.com/maragudk/service/model"
+)
+
+// AdminJobStates in the order they're shown.
+var AdminJobStates = []string{
+	string(model.JobStatePending),
+	string(model.JobStateRunning),
+	string(model.JobStateScheduled),
+	string(model.JobStateFailed),
+}
+
+type AdminJobsPageProps struct {
+	Counts     []model.JobCount
+	FailedJobs []model.FailedJob
+	Jobs       []model.Job
+	Name       string
+	State      string
+}
+
+func AdminJobsPage(p PageProps, props AdminJobsPageProps) g.Node {
+	p.Title = "Jobs"
+	p.Description = "Jobs in the queue."
+
+	return Page(p,
+		H1(g.Text("Jobs")),
+
+		H2(g.Text("Counts")),
+		adminJobCounts(props.Counts),
+
+		H2(g.Text("Jobs")),
+		adminJobsFilter(props),
+		g.If(props.State != string(model.JobStateFailed), adminJobs(props)),
+		g.If(props.State == string(model.JobStateFailed), adminFailedJobs(props)),
+	)
+}
+
+func adminJobCounts(counts []model.JobCount) g.Node {
+	var rows []g.Node
+	for _, count := range counts {
+		rows = append(rows, Tr(
+			Td(A(Href(adminJobsURL(count.Name, string(model.JobStatePending))), g.Text(count.Name))),
+			Td(g.Text(strconv.Itoa(count.Pending))),
+			Td(g.Text(strconv.Itoa(count.Running))),
+			Td(g.Text(strconv.Itoa(count.Scheduled))),
+			Td(A(Href(adminJobsURL(count.Name, string(model.JobStateFailed))), g.Text(strconv.Itoa(count.Failed)))),
+		))
+	}
+
+	return Table(
+		THead(Tr(Th(g.Text("Name")), Th(g.Text("Pending")), Th(g.Text("Running")), Th(g.Text("Scheduled")), Th(g.Text("Failed")))),
+		TBody(g.Group(rows)),
+	)
+}
+
+func adminJobsFilter(props AdminJobsPageProps) g.Node {
+	var stateLinks []g.Node
+	for _, state := range AdminJobStates {
+		stateLinks = append(stateLinks, A(
+			c.Classes{"mr-4": true, "font-bold": state == props.State},
+			Href(adminJobsURL(props.Name, state)), g.Text(state),
+		))
+	}
+
+	nameOptions := []g.Node{Option(Value(""), g.Text("All names"))}
+	for _, count := range props.Counts {
+		nameOptions = append(nameOptions, Option(Value(count.Name), g.If(count.Name == props.Name, Selected()), g.Text(count.Name)))
+	}
+
+	return Div(
+		P(g.Group(stateLinks)),
+		FormEl(Method("get"), Action("/admin/jobs"),
+			Input(Type("hidden"), Name("state"), Value(props.State)),
+			Select(Name("name"), g.Group(nameOptions)),
+			Button(Type("submit"), Class("ml-2"), g.Text("Filter")),
+		),
+	)
+}
+
+func adminJobs(props AdminJobsPageProps) g.Node {
+	if len(props.Jobs) == 0 {
+		return P(g.Text("No jobs."))
+	}
+
+	var rows []g.Node
+	for _, j := range props.Jobs {
+		received := ""
+		if j.Received != nil {
+			received = formatAdminTime(*j.Received)
+		}
+		rows = append(rows, Tr(
+			Td(g.Text(strconv.Itoa(j.ID))),
+			Td(g.Text(j.Name)),
+			Td(g.Text(j.Queue)),
+			Td(g.Textf("%v/%v", j.Attempts, j.MaxAttempts)),
+			Td(g.Text(formatAdminTime(j.Run))),
+			Td(g.Text(received)),
+			Td(g.Text(j.Schedule)),
+			Td(
+				g.If(props.State != string(model.JobStateRunning),
+					adminJobAction("/admin/jobs/"+strconv.Itoa(j.ID)+"/run", "Run now", props)),
+				adminJobAction("/admin/jobs/"+strconv.Itoa(j.ID)+"/delete", "Delete", props),
+			),
+		))
+	}
+
+	return Table(
+		THead(Tr(
+			Th(g.Text("ID")), Th(g.Text("Name")), Th(g.Text("Queue")), Th(g.Text("Attempts")), Th(g.Text("Run")),
+			Th(g.Text("Received")), Th(g.Text("Schedule")), Th(),
+		)),
+		TBody(g.Group(rows)),
+	)
+}
+
+func adminFailedJobs(props AdminJobsPageProps) g.Node {
+	if len(props.FailedJobs) == 0 {
+		return P(g.Text("No failed jobs."))
+	}
+
+	var rows []g.Node
+	for _, j := range props.FailedJobs {
+		rows = append(rows, Tr(
+			Td(g.Text(strconv.Itoa(j.ID))),
+			Td(g.Text(j.Name), g.If(j.Quarantined, Em(g.Text(" (quarantined)")))),
+			Td(g.Text(j.Queue)),
+			Td(g.Textf("%v/%v", j.Attempts, j.MaxAttempts)),
+			Td(g.Text(j.Error)),
+			Td(g.Text(formatAdminTime(j.Failed))),
+			Td(
+				adminJobAction("/admin/failed-jobs/"+strconv.Itoa(j.ID)+"/retry", "Retry", props),
+				adminJobAction("/admin/failed-jobs/"+strconv.Itoa(j.ID)+"/delete", "Delete", props),
+			),
+		))
+	}
+
+	return Table(
+		THead(Tr(
+			Th(g.Text("ID")), Th(g.Text("Name")), Th(g.Text("Queue")), Th(g.Text("Attempts")), Th(g.Text("Error")),
+			Th(g.Text("Failed")), Th(),
+		)),
+		TBody(g.Group(rows)),
+	)
+}
+
+// adminJobAction is a button posting to the action, keeping the current filter to redirect back to.
+func adminJobAction(action, text string, props AdminJobsPageProps) g.Node {
+	return FormEl(Method("post"), Action(action), Class("inline mr-2"),
+		Input(Type("hidden"), Name("name"), Value(props.Name)),
+		Input(Type("hidden"), Name("state"), Value(props.State)),
+		Button(Type("submit"), g.Text(text)),
+	)
+}
+
+func adminJobsURL(name, state string) string {
+	v := url.Values{}
+	if name != "" {
+		v.Set("name", name)
+	}
+	v.Set("state", state)
+	return "/admin/jobs?" + v.Encode()
+}
+
+func formatAdminTime(t model.Time) string {
+	return t.T.UTC().Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	g "github.com/maragudk/gomponents"
+	ghttp "github.com/maragudk/gomponents/http"
+
+	"github.com/maragudk/service/html"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+type adminStore interface {
+	GetJobs(ctx context.Context, opts sql.GetJobsOptions) ([]model.Job, error)
+	GetJobCounts(ctx context.Context) ([]model.JobCount, error)
+	GetFailedJobs(ctx context.Context, opts sql.GetFailedJobsOptions) ([]model.FailedJob, error)
+	RunJobNow(ctx context.Context, id int) error
+	CancelJob(ctx context.Context, id int) error
+	RequeueFailedJob(ctx context.Context, id int) error
+	DeleteFailedJob(ctx context.Context, id int) error
+}
+
+// Admin pages for inspecting and managing jobs.
+// The routes are not protected by themselves, so they must be mounted behind authentication.
+// Errors are logged, and not shown on the pages.
+func Admin(mux chi.Router, db adminStore, log *log.Logger) {
+	mux.Get("/admin/jobs", ghttp.Adapt(func(w http.ResponseWriter, r *http.Request) (g.Node, error) {
+		props := html.AdminJobsPageProps{
+			Name:  r.URL.Query().Get("name"),
+			State: r.URL.Query().Get("state"),
+		}
+		if !isAdminJobState(props.State) {
+			props.State = string(model.JobStatePending)
+		}
+
+		var err error
+		props.Counts, err = db.GetJobCounts(r.Context())
+		if err != nil {
+			log.Println("Error getting job counts:", err)
+			return html.ErrorPage(), err
+		}
+
+		if props.State == string(model.JobStateFailed) {
+			props.FailedJobs, err = db.GetFailedJobs(r.Context(), sql.GetFailedJobsOptions{Name: props.Name})
+		} else {
+			props.Jobs, err = db.GetJobs(r.Context(), sql.GetJobsOptions{Name: props.Name, State: model.JobState(props.State)})
+		}
+		if err != nil {
+			log.Println("Error getting jobs:", err)
+			return html.ErrorPage(), err
+		}
+
+		return html.AdminJobsPage(html.PageProps{}, props), nil
+	}))
+
+	mux.Post("/admin/jobs/{id}/run", adminJobAction(log, db.RunJobNow))
+	mux.Post("/admin/jobs/{id}/delete", adminJobAction(log, db.CancelJob))
+	mux.Post("/admin/failed-jobs/{id}/retry", adminJobAction(log, db.RequeueFailedJob))
+	mux.Post("/admin/failed-jobs/{id}/delete", adminJobAction(log, db.DeleteFailedJob))
+}
+
+func isAdminJobState(state string) bool {
+	for _, s := range html.AdminJobStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// adminJobAction calls the action with the job ID from the URL and redirects back to the jobs page with the same filter.
+func adminJobAction(log *log.Logger, action func(ctx context.Context, id int) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Browsers always send the origin for form posts, so this guards against cross-site requests
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin request", http.StatusForbidden)
+				return
+			}
+		}
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
+		}
+
+		if err := action(r.Context(), id); err != nil {
+			log.Println("Error in admin job action:", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		v := url.Values{}
+		if name := r.FormValue("name"); name != "" {
+			v.Set("name", name)
+		}
+		if state := r.FormValue("state"); state != "" {
+			v.Set("state", state)
+		}
+		http.Redirect(w, r, "/admin/jobs?"+v.Encode(), http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,98 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/maragudk/service/http"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+type adminStoreMock struct {
+	cancelled []int
+}
+
+func (a *adminStoreMock) GetJobs(ctx context.Context, opts sql.GetJobsOptions) ([]model.Job, error) {
+	return nil, nil
+}
+
+func (a *adminStoreMock) GetJobCounts(ctx context.Context) ([]model.JobCount, error) {
+	return nil, nil
+}
+
+func (a *adminStoreMock) GetFailedJobs(ctx context.Context, opts sql.GetFailedJobsOptions) ([]model.FailedJob, error) {
+	return nil, nil
+}
+
+func (a *adminStoreMock) RunJobNow(ctx context.Context, id int) error {
+	return nil
+}
+
+func (a *adminStoreMock) CancelJob(ctx context.Context, id int) error {
+	a.cancelled = append(a.cancelled, id)
+	return nil
+}
+
+func (a *adminStoreMock) RequeueFailedJob(ctx context.Context, id int) error {
+	return nil
+}
+
+func (a *adminStoreMock) DeleteFailedJob(ctx context.Context, id int) error {
+	return nil
+}
+
+func TestAdmin(t *testing.T) {
+	setup := func() (*chi.Mux, *adminStoreMock) {
+		db := &adminStoreMock{}
+		mux := chi.NewMux()
+		Admin(mux, db, log.New(io.Discard, "", 0))
+		return mux, db
+	}
+
+	t.Run("rejects cross-origin job actions", func(t *testing.T) {
+		mux, db := setup()
+
+		w := postForm(mux, "/admin/jobs/1/delete", "https://evil.example", url.Values{})
+		require.Equal(t, http.StatusForbidden, w.Code)
+		require.Len(t, db.cancelled, 0)
+	})
+
+	t.Run("allows same-origin job actions and redirects back with the filters", func(t *testing.T) {
+		mux, db := setup()
+
+		w := postForm(mux, "/admin/jobs/1/delete", "http://example.com", url.Values{"name": {"test"}, "state": {"scheduled"}})
+		require.Equal(t, http.StatusSeeOther, w.Code)
+		require.Equal(t, "/admin/jobs?name=test&state=scheduled", w.Header().Get("Location"))
+		require.Equal(t, []int{1}, db.cancelled)
+	})
+
+	t.Run("returns bad request on an invalid id", func(t *testing.T) {
+		mux, db := setup()
+
+		w := postForm(mux, "/admin/jobs/abc/delete", "", url.Values{})
+		require.Equal(t, http.StatusBadRequest, w.Code)
+		require.Len(t, db.cancelled, 0)
+	})
+}
+
+// postForm to the handler with the given origin header, if any.
+func postForm(h http.Handler, path, origin string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
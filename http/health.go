@@ -8,18 +8,36 @@ import (
 	"github.com/go-chi/chi/v5"
 
 	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
 )
 
-type jobCreator interface {
-	CreateJob(ctx context.Context, name string, payload model.Map, timeout time.Duration) error
+type healthChecker interface {
+	CreateJob(ctx context.Context, name string, payload any, timeout time.Duration, opts ...sql.JobOption) (int, error)
+	IsPrimary() (bool, string, error)
+	Ping(ctx context.Context) error
 }
 
-func Health(mux chi.Router, db jobCreator) {
+func Health(mux chi.Router, db healthChecker) {
 	mux.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		if err := db.CreateJob(r.Context(), "health", model.Map{}, time.Second); err != nil {
+		primary, _, err := db.IsPrimary()
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+
+		// Replicas cannot write, so only the primary checks that jobs can be created, and replicas check that they can read.
+		// The key makes sure health jobs don't pile up if the runner is down.
+		if primary {
+			if _, err := db.CreateJob(r.Context(), "health", model.Map{}, time.Second, sql.WithKey("health")); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		} else {
+			if err := db.Ping(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 		_, _ = w.Write([]byte("OK"))
 	})
 }
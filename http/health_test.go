@@ -0,0 +1,77 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	. "github.com/maragudk/service/http"
+	"github.com/maragudk/service/sql"
+)
+
+type healthCheckerMock struct {
+	primaryCheckerMock
+	createdJobs int
+	pinged      bool
+	pingErr     error
+}
+
+func (h *healthCheckerMock) CreateJob(ctx context.Context, name string, payload any, timeout time.Duration, opts ...sql.JobOption) (int, error) {
+	h.createdJobs++
+	return h.createdJobs, nil
+}
+
+func (h *healthCheckerMock) Ping(ctx context.Context) error {
+	h.pinged = true
+	return h.pingErr
+}
+
+func TestHealth(t *testing.T) {
+	t.Run("creates a health job on the primary", func(t *testing.T) {
+		db := &healthCheckerMock{primaryCheckerMock: primaryCheckerMock{primary: true}}
+		mux := chi.NewMux()
+		Health(mux, db)
+
+		code, _, body := makeRequest(mux, http.MethodGet, "/health")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "OK", body)
+		require.Equal(t, 1, db.createdJobs)
+		require.False(t, db.pinged)
+	})
+
+	t.Run("reads the database on replicas", func(t *testing.T) {
+		db := &healthCheckerMock{primaryCheckerMock: primaryCheckerMock{hostname: "machine-1"}}
+		mux := chi.NewMux()
+		Health(mux, db)
+
+		code, _, body := makeRequest(mux, http.MethodGet, "/health")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "OK", body)
+		require.Equal(t, 0, db.createdJobs)
+		require.True(t, db.pinged)
+	})
+
+	t.Run("returns an error if the database can't be read on replicas", func(t *testing.T) {
+		db := &healthCheckerMock{primaryCheckerMock: primaryCheckerMock{hostname: "machine-1"}, pingErr: errors.New("oh no")}
+		mux := chi.NewMux()
+		Health(mux, db)
+
+		code, _, body := makeRequest(mux, http.MethodGet, "/health")
+		require.Equal(t, http.StatusInternalServerError, code)
+		require.Equal(t, "oh no\n", body)
+	})
+}
+
+// makeRequest with the given method and path to the handler, returning the status code, headers and body.
+func makeRequest(h http.Handler, method, path string) (int, http.Header, string) {
+	r := httptest.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code, w.Header(), w.Body.String()
+}
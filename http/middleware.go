@@ -49,6 +49,44 @@ func Metrics(mux chi.Router, registry *prometheus.Registry) {
 	mux.Get("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP)
 }
 
+type primaryChecker interface {
+	IsPrimary() (bool, string, error)
+}
+
+// ReplayWritesToPrimary asks the Fly proxy to replay requests that may write, which is all but GET, HEAD and OPTIONS
+// requests, on the primary instance with the fly-replay header, if this instance is not the primary.
+// See https://fly.io/docs/reference/dynamic-request-routing/
+func ReplayWritesToPrimary(db primaryChecker) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			primary, hostname, err := db.IsPrimary()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if primary {
+				next.ServeHTTP(w, r)
+				return
+			}
+			// There may be no primary for a moment, for example while a new one is elected
+			if hostname == "" {
+				http.Error(w, "no primary to replay the request on", http.StatusServiceUnavailable)
+				return
+			}
+
+			// The Fly proxy replays the request and never returns this response, so the status is only seen elsewhere
+			w.Header().Set("fly-replay", "instance="+hostname)
+			w.WriteHeader(http.StatusConflict)
+		})
+	}
+}
+
 var versionedAssetMatcher = regexp.MustCompile(`([^.]+)\.[a-z0-9]+(\.(?:js|css))`)
 
 func VersionedAssets(next http.Handler) http.Handler {
@@ -0,0 +1,68 @@
+package http_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "github.com/maragudk/service/http"
+)
+
+type primaryCheckerMock struct {
+	primary  bool
+	hostname string
+	err      error
+}
+
+func (p *primaryCheckerMock) IsPrimary() (bool, string, error) {
+	return p.primary, p.hostname, p.err
+}
+
+func TestReplayWritesToPrimary(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK"))
+	})
+
+	t.Run("passes GET requests through on replicas", func(t *testing.T) {
+		h := ReplayWritesToPrimary(&primaryCheckerMock{hostname: "machine-1"})(next)
+
+		code, headers, body := makeRequest(h, http.MethodGet, "/")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "", headers.Get("fly-replay"))
+		require.Equal(t, "OK", body)
+	})
+
+	t.Run("passes POST requests through on the primary", func(t *testing.T) {
+		h := ReplayWritesToPrimary(&primaryCheckerMock{primary: true})(next)
+
+		code, _, body := makeRequest(h, http.MethodPost, "/")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, "OK", body)
+	})
+
+	t.Run("replays POST requests on the primary from replicas", func(t *testing.T) {
+		h := ReplayWritesToPrimary(&primaryCheckerMock{hostname: "machine-1"})(next)
+
+		code, headers, _ := makeRequest(h, http.MethodPost, "/")
+		require.Equal(t, http.StatusConflict, code)
+		require.Equal(t, "instance=machine-1", headers.Get("fly-replay"))
+	})
+
+	t.Run("returns service unavailable if there is no primary", func(t *testing.T) {
+		h := ReplayWritesToPrimary(&primaryCheckerMock{})(next)
+
+		code, headers, _ := makeRequest(h, http.MethodPost, "/")
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, "", headers.Get("fly-replay"))
+	})
+
+	t.Run("returns an error if the primary can't be checked", func(t *testing.T) {
+		h := ReplayWritesToPrimary(&primaryCheckerMock{err: errors.New("oh no")})(next)
+
+		code, _, body := makeRequest(h, http.MethodPost, "/")
+		require.Equal(t, http.StatusInternalServerError, code)
+		require.Equal(t, "oh no\n", body)
+	})
+}
@@ -11,6 +11,7 @@ func (s *Server) setupRoutes() {
 	s.mux.Use(middleware.Compress(5))
 	s.mux.Use(middleware.RealIP)
 	s.mux.Use(AddMetrics(s.metrics))
+	s.mux.Use(ReplayWritesToPrimary(s.database))
 
 	Health(s.mux, s.database)
 	Metrics(s.mux, s.metrics)
@@ -27,5 +28,14 @@ func (s *Server) setupRoutes() {
 		Home(r)
 	})
 
+	if s.adminPassword != "" {
+		s.mux.Group(func(r chi.Router) {
+			r.Use(middleware.BasicAuth("admin", map[string]string{"admin": s.adminPassword}))
+			r.Use(middleware.SetHeader("Content-Type", "text/html; charset=utf-8"))
+
+			Admin(r, s.database, s.log)
+		})
+	}
+
 	Migrate(s.mux, s.database)
 }
@@ -18,22 +18,25 @@ import (
 )
 
 type Server struct {
-	address     string
-	database    *sql.Database
-	log         *log.Logger
-	metrics     *prometheus.Registry
-	mux         chi.Router
-	objectStore *s3.ObjectStore
-	server      *http.Server
+	address       string
+	adminPassword string
+	database      *sql.Database
+	log           *log.Logger
+	metrics       *prometheus.Registry
+	mux           chi.Router
+	objectStore   *s3.ObjectStore
+	server        *http.Server
 }
 
 type NewServerOptions struct {
-	Database    *sql.Database
-	Host        string
-	Log         *log.Logger
-	Metrics     *prometheus.Registry
-	ObjectStore *s3.ObjectStore
-	Port        int
+	// AdminPassword for the admin pages, with the username "admin". If empty, the admin pages are disabled.
+	AdminPassword string
+	Database      *sql.Database
+	Host          string
+	Log           *log.Logger
+	Metrics       *prometheus.Registry
+	ObjectStore   *s3.ObjectStore
+	Port          int
 }
 
 // NewServer returns an initialized, but unstarted Server.
@@ -51,12 +54,13 @@ func NewServer(opts NewServerOptions) *Server {
 	mux := chi.NewMux()
 
 	return &Server{
-		address:     address,
-		database:    opts.Database,
-		log:         opts.Log,
-		metrics:     opts.Metrics,
-		mux:         mux,
-		objectStore: opts.ObjectStore,
+		address:       address,
+		adminPassword: opts.AdminPassword,
+		database:      opts.Database,
+		log:           opts.Log,
+		metrics:       opts.Metrics,
+		mux:           mux,
+		objectStore:   opts.ObjectStore,
 		server: &http.Server{
 			Addr:              address,
 			Handler:           mux,
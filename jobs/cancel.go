@@ -0,0 +1,120 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+)
+
+// canceller is a queue that can cancel jobs.
+type canceller interface {
+	CancelJob(ctx context.Context, id int) error
+	CancelJobsByName(ctx context.Context, name string) (int, error)
+}
+
+// pauser is a queue that can pause and resume jobs by name.
+type pauser interface {
+	PauseJobs(ctx context.Context, name string) error
+	ResumeJobs(ctx context.Context, name string) error
+}
+
+// runningJob in this Runner, which can be cancelled.
+type runningJob struct {
+	cancel    context.CancelFunc
+	cancelled bool
+	name      string
+}
+
+func (r *Runner) addRunningJob(j *model.Job, cancel context.CancelFunc) {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	r.running[j.ID] = &runningJob{cancel: cancel, name: j.Name}
+}
+
+func (r *Runner) removeRunningJob(id int) {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	delete(r.running, id)
+}
+
+// cancelRunningJob with the given ID, if it's running in this Runner.
+func (r *Runner) cancelRunningJob(id int) {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	if rj, ok := r.running[id]; ok {
+		rj.cancelled = true
+		rj.cancel()
+	}
+}
+
+func (r *Runner) isCancelled(id int) bool {
+	r.runningLock.Lock()
+	defer r.runningLock.Unlock()
+	rj, ok := r.running[id]
+	return ok && rj.cancelled
+}
+
+// CancelJob with the given ID.
+// The job is removed from the queue, and if it's running in this Runner, its context is cancelled.
+// Runners in other processes cancel the context when they next check the queue, every poll interval,
+// if the queue supports it like sql.Database does. Cancelled jobs are not retried or rescheduled either way.
+func (r *Runner) CancelJob(ctx context.Context, id int) error {
+	c, ok := r.queue.(canceller)
+	if !ok {
+		return errors.New("queue does not support cancelling jobs")
+	}
+
+	// Only cancel here once it's gone from the queue, since a cancelled job is left alone when it returns
+	if err := c.CancelJob(ctx, id); err != nil {
+		return err
+	}
+
+	r.cancelRunningJob(id)
+
+	return nil
+}
+
+// CancelJobs with the given name, like CancelJob, returning how many were cancelled in the queue.
+func (r *Runner) CancelJobs(ctx context.Context, name string) (int, error) {
+	c, ok := r.queue.(canceller)
+	if !ok {
+		return 0, errors.New("queue does not support cancelling jobs")
+	}
+
+	n, err := c.CancelJobsByName(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	r.runningLock.Lock()
+	for _, rj := range r.running {
+		if rj.name == name {
+			rj.cancelled = true
+			rj.cancel()
+		}
+	}
+	r.runningLock.Unlock()
+
+	return n, nil
+}
+
+// PauseJobs with the given name across all runners sharing the queue, until resumed with ResumeJobs.
+// Running jobs are not affected, use CancelJobs for that.
+func (r *Runner) PauseJobs(ctx context.Context, name string) error {
+	p, ok := r.queue.(pauser)
+	if !ok {
+		return errors.New("queue does not support pausing jobs")
+	}
+	return p.PauseJobs(ctx, name)
+}
+
+// ResumeJobs with the given name, after they've been paused with PauseJobs.
+func (r *Runner) ResumeJobs(ctx context.Context, name string) error {
+	p, ok := r.queue.(pauser)
+	if !ok {
+		return errors.New("queue does not support pausing jobs")
+	}
+	return p.ResumeJobs(ctx, name)
+}
@@ -0,0 +1,201 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestRunner_CancelJob(t *testing.T) {
+	t.Run("cancels the context of a running job and removes it from the queue", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		running := make(chan struct{})
+		var jobErr error
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			close(running)
+			<-ctx.Done()
+			jobErr = ctx.Err()
+			cancel()
+			return jobErr
+		})
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		go func() {
+			<-running
+			err := runner.CancelJob(context.Background(), id)
+			assert.NoError(t, err)
+		}()
+
+		runner.Start(ctx)
+
+		require.ErrorIs(t, jobErr, context.Canceled)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 0)
+	})
+
+	t.Run("cancels the context of a running job cancelled directly in the queue", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		running := make(chan struct{})
+		var jobErr error
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			close(running)
+			<-ctx.Done()
+			jobErr = ctx.Err()
+			cancel()
+			return jobErr
+		})
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		// Like another process cancelling the job, for example through the admin pages
+		go func() {
+			<-running
+			err := db.CancelJob(context.Background(), id)
+			assert.NoError(t, err)
+		}()
+
+		runner.Start(ctx)
+
+		require.ErrorIs(t, jobErr, context.Canceled)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 0)
+	})
+
+	t.Run("doesn't cancel a running job if cancelling it in the queue fails", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+		q := &failingCanceller{Database: db}
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        q,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		running := make(chan struct{})
+		cancelled := make(chan error)
+		var jobErr error
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			close(running)
+			jobErr = <-cancelled
+			cancel()
+			return ctx.Err()
+		})
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		go func() {
+			<-running
+			cancelled <- runner.CancelJob(context.Background(), id)
+		}()
+
+		runner.Start(ctx)
+
+		require.Error(t, jobErr)
+
+		// The job finished normally, so it's deleted from the queue
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+}
+
+type failingCanceller struct {
+	*sql.Database
+}
+
+func (q *failingCanceller) CancelJob(ctx context.Context, id int) error {
+	return errors.New("oh no")
+}
+
+func (q *failingCanceller) CancelJobsByName(ctx context.Context, name string) (int, error) {
+	return 0, errors.New("oh no")
+}
+
+func TestRunner_PauseJobs(t *testing.T) {
+	t.Run("pauses and resumes jobs by name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		ran := make(chan struct{})
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			close(ran)
+			cancel()
+			return nil
+		})
+
+		err := runner.PauseJobs(context.Background(), "test")
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			select {
+			case <-ran:
+				t.Error("job ran while paused")
+			default:
+			}
+			err := runner.ResumeJobs(context.Background(), "test")
+			assert.NoError(t, err)
+		}()
+
+		runner.Start(ctx)
+
+		select {
+		case <-ran:
+		default:
+			t.Fatal("job didn't run after resuming")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"github.com/maragudk/service/model"
+)
+
+// historyStore is a queue that can keep a history of job runs.
+type historyStore interface {
+	CreateJobRun(ctx context.Context, r model.JobRun) error
+	DeleteJobRunsBefore(ctx context.Context, t time.Time) (int, error)
+}
+
+// recordRun of the job in the history, if enabled.
+func (r *Runner) recordRun(ctx context.Context, j *model.Job, started time.Time, duration time.Duration,
+	outcome model.JobRunOutcome, err error) {
+	if r.history == nil {
+		return
+	}
+
+	run := model.JobRun{
+		JobID:   j.ID,
+		Name:    j.Name,
+		Queue:   j.Queue,
+		Payload: j.Payload,
+		Attempt: j.Attempts,
+		Started: model.Time{T: started},
+		Ended:   model.Time{T: started.Add(duration)},
+		Outcome: outcome,
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if err := r.history.CreateJobRun(ctx, run); err != nil {
+		r.log.Println("Error recording job run:", err)
+	}
+}
+
+// CleanupJobRuns every hour, deleting job runs older than the retention duration.
+// The given now function is used to get the current time, like NewRunnerOptions.Now.
+func CleanupJobRuns(r registry, h historyStore, retention time.Duration, now func() time.Time) {
+	r.RegisterSchedule("cleanup-job-runs", Every(time.Hour), time.Minute, func(ctx context.Context, m model.Map) error {
+		_, err := h.DeleteJobRunsBefore(ctx, now().Add(-retention))
+		return err
+	})
+}
@@ -0,0 +1,43 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/jobstest"
+	"github.com/maragudk/service/model"
+)
+
+func TestCleanupJobRuns(t *testing.T) {
+	t.Run("deletes job runs older than the retention on the runner's clock", func(t *testing.T) {
+		q := jobstest.CreateQueue(t)
+		runner := jobstest.CreateRunner(t, q)
+
+		h := &historyStoreMock{}
+		jobs.CleanupJobRuns(runner, h, 24*time.Hour, q.Clock.Now)
+
+		// The cleanup job is scheduled for the next hour
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+		q.Clock.Advance(time.Hour)
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+
+		require.Equal(t, q.Clock.Now().Add(-24*time.Hour), h.before)
+	})
+}
+
+type historyStoreMock struct {
+	before time.Time
+}
+
+func (h *historyStoreMock) CreateJobRun(ctx context.Context, r model.JobRun) error {
+	return nil
+}
+
+func (h *historyStoreMock) DeleteJobRunsBefore(ctx context.Context, t time.Time) (int, error) {
+	h.before = t
+	return 0, nil
+}
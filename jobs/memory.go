@@ -0,0 +1,746 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+// MemoryQueue is a Queue that keeps jobs in memory, with the same semantics as sql.Database:
+// jobs run at their run time, received jobs are invisible until their timeout or lease expires,
+// and they're gone when deleted. It supports the same job options, scheduled jobs, cancelling, rate limits,
+// quarantining, and progress and results, but not pausing or job history.
+// Jobs are lost when the process stops, so it's meant for tests and ephemeral single-process setups.
+type MemoryQueue struct {
+	claims          map[string][]jobClaim
+	dependencies    map[int]map[int]struct{}
+	failed          []model.FailedJob
+	jobs            map[int]*model.Job
+	lock            sync.Mutex
+	nextID          int
+	nextFailedID    int
+	now             func() time.Time
+	results         map[int]model.JobStatus
+	subscribers     map[chan struct{}]struct{}
+	subscribersLock sync.Mutex
+}
+
+// NewMemoryQueueOptions for NewMemoryQueue.
+// Now is used to get the current time, and defaults to time.Now.
+type NewMemoryQueueOptions struct {
+	Now func() time.Time
+}
+
+// NewMemoryQueue with the given options.
+func NewMemoryQueue(opts NewMemoryQueueOptions) *MemoryQueue {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &MemoryQueue{
+		claims:       map[string][]jobClaim{},
+		dependencies: map[int]map[int]struct{}{},
+		jobs:         map[int]*model.Job{},
+		nextID:       1,
+		nextFailedID: 1,
+		now:          opts.Now,
+		results:      map[int]model.JobStatus{},
+		subscribers:  map[chan struct{}]struct{}{},
+	}
+}
+
+// CreateJob to run immediately, returning its ID. See sql.Database.CreateJob.
+func (q *MemoryQueue) CreateJob(ctx context.Context, name string, payload any, timeout time.Duration, opts ...sql.JobOption) (int, error) {
+	return q.CreateJobForLater(ctx, name, payload, timeout, 0, opts...)
+}
+
+// CreateJobForLater to run after the given duration, returning its ID. See sql.Database.CreateJobForLater.
+func (q *MemoryQueue) CreateJobForLater(ctx context.Context, name string, payload any, timeout, after time.Duration, opts ...sql.JobOption) (int, error) {
+	if name == "" {
+		panic("job name cannot be empty")
+	}
+
+	o := sql.ApplyJobOptions(opts...)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding job payload")
+	}
+
+	q.lock.Lock()
+	defer q.notifyJobCreated()
+	defer q.lock.Unlock()
+
+	if o.Key != "" {
+		for _, j := range q.jobs {
+			if j.Name == name && j.Key == o.Key && j.Received == nil {
+				return j.ID, nil
+			}
+		}
+	}
+
+	for _, dependencyID := range o.Dependencies {
+		for _, f := range q.failed {
+			if f.JobID == dependencyID {
+				return 0, errors.Newf("dependency %v has failed", dependencyID)
+			}
+		}
+	}
+
+	now := q.now()
+	j := &model.Job{
+		ID:          q.nextID,
+		Name:        name,
+		Queue:       o.Queue,
+		Priority:    o.Priority,
+		Payload:     data,
+		Timeout:     timeout,
+		Lease:       o.Lease,
+		Run:         model.Time{T: now.Add(after)},
+		MaxAttempts: o.MaxAttempts,
+		Key:         o.Key,
+		Batch:       o.Batch,
+		Created:     model.Time{T: now},
+		Updated:     model.Time{T: now},
+	}
+	q.nextID++
+	q.jobs[j.ID] = j
+
+	for _, dependencyID := range o.Dependencies {
+		if _, ok := q.jobs[dependencyID]; !ok {
+			continue
+		}
+		if q.dependencies[j.ID] == nil {
+			q.dependencies[j.ID] = map[int]struct{}{}
+		}
+		q.dependencies[j.ID][dependencyID] = struct{}{}
+	}
+
+	return j.ID, nil
+}
+
+// GetJob which is eligible to run. Returns nil if no job available. See sql.Database.GetJob.
+func (q *MemoryQueue) GetJob(ctx context.Context, opts sql.GetJobOptions) (*model.Job, error) {
+	if opts.Queue == "" {
+		opts.Queue = sql.DefaultQueue
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+
+	var candidates []*model.Job
+	for _, j := range q.jobs {
+		if j.Queue != opts.Queue || len(q.dependencies[j.ID]) > 0 || !isDue(j, now) || !isVisible(j, now) {
+			continue
+		}
+		if len(opts.Names) > 0 && !contains(opts.Names, j.Name) {
+			continue
+		}
+		if q.isRateLimited(j.Name, opts.RateLimits, now) {
+			continue
+		}
+		candidates = append(candidates, j)
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(candidates, func(i, k int) bool {
+		a, b := candidates[i], candidates[k]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if !a.Created.T.Equal(b.Created.T) {
+			return a.Created.T.Before(b.Created.T)
+		}
+		return a.ID < b.ID
+	})
+
+	j := candidates[0]
+	j.Received = &model.Time{T: now}
+	j.Attempts++
+	j.Progress = 0
+	j.ProgressMessage = ""
+	j.Result = nil
+	j.Updated = model.Time{T: now}
+	for _, l := range opts.RateLimits {
+		if l.Name == j.Name {
+			q.claim(j, now)
+			break
+		}
+	}
+
+	job := *j
+	return &job, nil
+}
+
+// isVisible if the job hasn't been received, or its timeout or lease has expired since.
+// Times are compared with millisecond precision, like the database does, so jobs become visible at the same time in both.
+func isVisible(j *model.Job, now time.Time) bool {
+	if j.Received == nil {
+		return true
+	}
+	visibility := j.Timeout
+	if j.Lease > 0 {
+		visibility = j.Lease
+	}
+	return !j.Received.T.Add(visibility).Truncate(time.Millisecond).After(now.Truncate(time.Millisecond))
+}
+
+// isDue if the job's run time has come. Like isVisible, times are compared with millisecond precision.
+func isDue(j *model.Job, now time.Time) bool {
+	return !j.Run.T.Truncate(time.Millisecond).After(now.Truncate(time.Millisecond))
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isRateLimited if the name has a rate limit, and it has been reached.
+func (q *MemoryQueue) isRateLimited(name string, limits []sql.RateLimit, now time.Time) bool {
+	for _, l := range limits {
+		if l.Name != name {
+			continue
+		}
+		var count int
+		for _, c := range q.claims[name] {
+			if c.claimed.After(now.Add(-l.Per)) {
+				count++
+			}
+		}
+		if count >= l.Limit {
+			return true
+		}
+	}
+	return false
+}
+
+// jobClaim is a record of receiving a job with a rate limit.
+type jobClaim struct {
+	claimed time.Time
+	id      int
+}
+
+// claim a job with a rate limit, forgetting claims for its name older than sql.MaxRateLimitInterval.
+func (q *MemoryQueue) claim(j *model.Job, now time.Time) {
+	var claims []jobClaim
+	for _, c := range q.claims[j.Name] {
+		if c.claimed.After(now.Add(-sql.MaxRateLimitInterval)) {
+			claims = append(claims, c)
+		}
+	}
+	q.claims[j.Name] = append(claims, jobClaim{claimed: now, id: j.ID})
+}
+
+// unclaim a job by forgetting its latest claim, if it has one.
+func (q *MemoryQueue) unclaim(j *model.Job) {
+	claims := q.claims[j.Name]
+	for i := len(claims) - 1; i >= 0; i-- {
+		if claims[i].id == j.ID {
+			q.claims[j.Name] = append(claims[:i:i], claims[i+1:]...)
+			return
+		}
+	}
+}
+
+// ExtendJobLease of a received job. See sql.Database.ExtendJobLease.
+func (q *MemoryQueue) ExtendJobLease(ctx context.Context, id int, received time.Time) (*model.Time, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok || j.Received == nil || !j.Received.T.Equal(received) {
+		return nil, nil
+	}
+	j.Received = &model.Time{T: q.now()}
+	t := *j.Received
+	return &t, nil
+}
+
+// RetryJob after the given duration. See sql.Database.RetryJob.
+func (q *MemoryQueue) RetryJob(ctx context.Context, id int, after time.Duration) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if j, ok := q.jobs[id]; ok && !q.supersede(j) {
+		j.Received = nil
+		j.Run = model.Time{T: q.now().Add(after)}
+	}
+	return nil
+}
+
+// ReleaseJob that was received but not finished. See sql.Database.ReleaseJob.
+func (q *MemoryQueue) ReleaseJob(ctx context.Context, id int) error {
+	q.lock.Lock()
+	j, ok := q.jobs[id]
+	if ok && j.Received != nil {
+		q.unclaim(j)
+		if !q.supersede(j) {
+			j.Received = nil
+			if j.Attempts > 0 {
+				j.Attempts--
+			}
+		}
+	}
+	q.lock.Unlock()
+
+	q.notifyJobCreated()
+	return nil
+}
+
+// DeleteJob after it has finished. See sql.Database.DeleteJob.
+func (q *MemoryQueue) DeleteJob(ctx context.Context, id int) error {
+	q.lock.Lock()
+	q.saveResult(id, "")
+	hasDependents := q.removeJob(id)
+	q.lock.Unlock()
+
+	if hasDependents {
+		q.notifyJobCreated()
+	}
+	return nil
+}
+
+// CancelJob and all jobs depending on it. See sql.Database.CancelJob.
+func (q *MemoryQueue) CancelJob(ctx context.Context, id int) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.jobs[id]; !ok {
+		return nil
+	}
+	for _, dependentID := range q.getDependents(id) {
+		q.removeJob(dependentID)
+	}
+	q.removeJob(id)
+	return nil
+}
+
+// CancelJobsByName and all jobs depending on them. See sql.Database.CancelJobsByName.
+func (q *MemoryQueue) CancelJobsByName(ctx context.Context, name string) (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	cancelled := map[int]struct{}{}
+	for _, j := range q.jobs {
+		if j.Name != name {
+			continue
+		}
+		cancelled[j.ID] = struct{}{}
+		for _, dependentID := range q.getDependents(j.ID) {
+			cancelled[dependentID] = struct{}{}
+		}
+	}
+	for id := range cancelled {
+		q.removeJob(id)
+	}
+	return len(cancelled), nil
+}
+
+// FailJob and all jobs depending on it. See sql.Database.FailJob.
+func (q *MemoryQueue) FailJob(ctx context.Context, id int, reason string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	dependents := q.getDependents(id)
+	q.failJob(id, reason)
+	for _, dependentID := range dependents {
+		q.failJob(dependentID, fmt.Sprintf("dependency %v failed: %v", id, reason))
+	}
+	return nil
+}
+
+// GetJobQueues that have jobs in them, sorted by name. See sql.Database.GetJobQueues.
+func (q *MemoryQueue) GetJobQueues(ctx context.Context) ([]string, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var queues []string
+	for _, j := range q.jobs {
+		if !contains(queues, j.Queue) {
+			queues = append(queues, j.Queue)
+		}
+	}
+	sort.Strings(queues)
+	return queues, nil
+}
+
+// QuarantineJobs with names that aren't known in their queue. See sql.Database.QuarantineJobs.
+func (q *MemoryQueue) QuarantineJobs(ctx context.Context, opts sql.QuarantineJobsOptions) (int, error) {
+	if len(opts.Names) == 0 || len(opts.Queues) == 0 {
+		return 0, nil
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+
+	var ids []int
+	for _, j := range q.jobs {
+		if contains(opts.Queues, j.Queue) && !contains(opts.Names, j.Name) &&
+			j.Run.T.Truncate(time.Millisecond).Before(now.Add(-opts.After).Truncate(time.Millisecond)) &&
+			isVisible(j, now) {
+			ids = append(ids, j.ID)
+		}
+	}
+	sort.Ints(ids)
+
+	var count int
+	for _, id := range ids {
+		// The job may already have been failed as a dependent of another quarantined job
+		if _, ok := q.jobs[id]; !ok {
+			continue
+		}
+		dependents := q.getDependents(id)
+		reason := "no job registered with this name in the runner for its queue"
+		q.failJob(id, reason)
+		q.failed[len(q.failed)-1].Quarantined = true
+		for _, dependentID := range dependents {
+			q.failJob(dependentID, fmt.Sprintf("dependency %v failed: %v", id, reason))
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (q *MemoryQueue) failJob(id int, reason string) {
+	j, ok := q.jobs[id]
+	if !ok {
+		return
+	}
+	q.saveResult(id, reason)
+	q.failed = append(q.failed, model.FailedJob{
+		ID:          q.nextFailedID,
+		Name:        j.Name,
+		Queue:       j.Queue,
+		Priority:    j.Priority,
+		Payload:     j.Payload,
+		Timeout:     j.Timeout,
+		Lease:       j.Lease,
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		Batch:       j.Batch,
+		Error:       reason,
+		JobID:       id,
+		Created:     j.Created,
+		Failed:      model.Time{T: q.now()},
+	})
+	q.nextFailedID++
+	q.removeJob(id)
+}
+
+// GetFailedJobs, most recently failed first. See sql.Database.GetFailedJobs.
+func (q *MemoryQueue) GetFailedJobs(ctx context.Context, opts sql.GetFailedJobsOptions) ([]model.FailedJob, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var jobs []model.FailedJob
+	for i := len(q.failed) - 1; i >= 0 && len(jobs) < opts.Limit; i-- {
+		if opts.Name == "" || q.failed[i].Name == opts.Name {
+			jobs = append(jobs, q.failed[i])
+		}
+	}
+	return jobs, nil
+}
+
+// ScheduleJob that recurs on the given schedule. See sql.Database.ScheduleJob.
+func (q *MemoryQueue) ScheduleJob(ctx context.Context, name, schedule string, timeout time.Duration, run time.Time, opts ...sql.JobOption) error {
+	if name == "" {
+		panic("job name cannot be empty")
+	}
+	if schedule == "" {
+		panic("job schedule cannot be empty")
+	}
+
+	o := sql.ApplyJobOptions(opts...)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, j := range q.jobs {
+		if j.Name != name || j.Schedule == "" {
+			continue
+		}
+		if j.Schedule != schedule || j.Timeout != timeout || j.Queue != o.Queue {
+			j.Schedule = schedule
+			j.Timeout = timeout
+			j.Queue = o.Queue
+			j.Run = model.Time{T: run}
+		}
+		return nil
+	}
+
+	now := q.now()
+	j := &model.Job{
+		ID:          q.nextID,
+		Name:        name,
+		Queue:       o.Queue,
+		Payload:     model.JSON(`{}`),
+		Timeout:     timeout,
+		Run:         model.Time{T: run},
+		MaxAttempts: sql.ApplyJobOptions().MaxAttempts,
+		Schedule:    schedule,
+		Created:     model.Time{T: now},
+		Updated:     model.Time{T: now},
+	}
+	q.nextID++
+	q.jobs[j.ID] = j
+	return nil
+}
+
+// RescheduleJob to run next at the given time. See sql.Database.RescheduleJob.
+func (q *MemoryQueue) RescheduleJob(ctx context.Context, id int, run time.Time) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if j, ok := q.jobs[id]; ok {
+		j.Received = nil
+		j.Attempts = 0
+		j.Run = model.Time{T: run}
+	}
+	return nil
+}
+
+// GetJobs in the given state, ordered by when they run. See sql.Database.GetJobs.
+func (q *MemoryQueue) GetJobs(ctx context.Context, opts sql.GetJobsOptions) ([]model.Job, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.now()
+
+	var jobs []model.Job
+	for _, j := range q.jobs {
+		if opts.Name != "" && j.Name != opts.Name {
+			continue
+		}
+		var inState bool
+		switch opts.State {
+		case model.JobStatePending:
+			inState = isVisible(j, now) && isDue(j, now)
+		case model.JobStateRunning:
+			inState = !isVisible(j, now)
+		case model.JobStateScheduled:
+			inState = isVisible(j, now) && !isDue(j, now)
+		default:
+			return nil, errors.Newf("unknown job state %v", opts.State)
+		}
+		if inState {
+			jobs = append(jobs, *j)
+		}
+	}
+
+	sort.Slice(jobs, func(i, k int) bool {
+		if !jobs[i].Run.T.Equal(jobs[k].Run.T) {
+			return jobs[i].Run.T.Before(jobs[k].Run.T)
+		}
+		return jobs[i].ID < jobs[k].ID
+	})
+	if len(jobs) > opts.Limit {
+		jobs = jobs[:opts.Limit]
+	}
+	return jobs, nil
+}
+
+// UpdateJobProgress of a received job. See sql.Database.UpdateJobProgress.
+func (q *MemoryQueue) UpdateJobProgress(ctx context.Context, id, progress int, message string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if j, ok := q.jobs[id]; ok && j.Received != nil {
+		j.Progress = progress
+		j.ProgressMessage = message
+	}
+	return nil
+}
+
+// SetJobResult of a received job. See sql.Database.SetJobResult.
+func (q *MemoryQueue) SetJobResult(ctx context.Context, id int, result any) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "error encoding job result")
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if j, ok := q.jobs[id]; ok && j.Received != nil {
+		j.Result = data
+	}
+	return nil
+}
+
+// GetJobStatus by job ID. Returns nil if there is no such job. See sql.Database.GetJobStatus.
+// Statuses of finished jobs are kept for as long as the queue exists.
+func (q *MemoryQueue) GetJobStatus(ctx context.Context, id int) (*model.JobStatus, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if s, ok := q.results[id]; ok {
+		return &s, nil
+	}
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+
+	now := q.now()
+	state := model.JobStatePending
+	switch {
+	case !isVisible(j, now):
+		state = model.JobStateRunning
+	case !isDue(j, now):
+		state = model.JobStateScheduled
+	}
+	return &model.JobStatus{
+		ID:              j.ID,
+		Name:            j.Name,
+		State:           state,
+		Progress:        j.Progress,
+		ProgressMessage: j.ProgressMessage,
+		Result:          j.Result,
+	}, nil
+}
+
+// saveResult of a job that is about to be removed, if it reported progress or a result.
+// The job failed if the reason is not empty.
+func (q *MemoryQueue) saveResult(id int, reason string) {
+	j, ok := q.jobs[id]
+	if !ok || (j.Progress == 0 && j.ProgressMessage == "" && j.Result == nil) {
+		return
+	}
+	state := model.JobStateCompleted
+	if reason != "" {
+		state = model.JobStateFailed
+	}
+	q.results[id] = model.JobStatus{
+		ID:              j.ID,
+		Name:            j.Name,
+		State:           state,
+		Progress:        j.Progress,
+		ProgressMessage: j.ProgressMessage,
+		Result:          j.Result,
+		Error:           reason,
+	}
+}
+
+// removeJob and its dependencies, returning whether other jobs depended on it.
+func (q *MemoryQueue) removeJob(id int) bool {
+	delete(q.jobs, id)
+	delete(q.dependencies, id)
+
+	var hasDependents bool
+	for jobID, dependencies := range q.dependencies {
+		if _, ok := dependencies[id]; !ok {
+			continue
+		}
+		hasDependents = true
+		delete(dependencies, id)
+		if len(dependencies) == 0 {
+			delete(q.dependencies, jobID)
+		}
+	}
+	return hasDependents
+}
+
+// supersede the job if a pending job with the same name and key exists, returning whether it did.
+// The job is removed, and its dependents depend on the pending job instead. See sql.Database.RetryJob.
+func (q *MemoryQueue) supersede(j *model.Job) bool {
+	if j.Key == "" {
+		return false
+	}
+
+	for _, pending := range q.jobs {
+		if pending.ID == j.ID || pending.Name != j.Name || pending.Key != j.Key || pending.Received != nil {
+			continue
+		}
+
+		for jobID, dependencies := range q.dependencies {
+			if _, ok := dependencies[j.ID]; ok && jobID != pending.ID {
+				dependencies[pending.ID] = struct{}{}
+			}
+		}
+		q.removeJob(j.ID)
+		return true
+	}
+	return false
+}
+
+// getDependents of a job, directly or indirectly, sorted by ID.
+func (q *MemoryQueue) getDependents(id int) []int {
+	seen := map[int]struct{}{}
+	var visit func(id int)
+	visit = func(id int) {
+		for jobID, dependencies := range q.dependencies {
+			if _, ok := dependencies[id]; !ok {
+				continue
+			}
+			if _, ok := seen[jobID]; ok {
+				continue
+			}
+			seen[jobID] = struct{}{}
+			visit(jobID)
+		}
+	}
+	visit(id)
+
+	var ids []int
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// SubscribeJobCreated like sql.Database.SubscribeJobCreated.
+func (q *MemoryQueue) SubscribeJobCreated() (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+
+	q.subscribersLock.Lock()
+	q.subscribers[c] = struct{}{}
+	q.subscribersLock.Unlock()
+
+	return c, func() {
+		q.subscribersLock.Lock()
+		delete(q.subscribers, c)
+		q.subscribersLock.Unlock()
+	}
+}
+
+// notifyJobCreated to all subscribers, without blocking.
+func (q *MemoryQueue) notifyJobCreated() {
+	q.subscribersLock.Lock()
+	defer q.subscribersLock.Unlock()
+
+	for c := range q.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/jobstest"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+func newMemoryQueue() (*jobs.MemoryQueue, *jobstest.Clock) {
+	c := jobstest.NewClock()
+	return jobs.NewMemoryQueue(jobs.NewMemoryQueueOptions{Now: c.Now}), c
+}
+
+func TestMemoryQueue_GetJob(t *testing.T) {
+	t.Run("gets a job to run now, only once until its timeout", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		id, err := q.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Minute)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, id, job.ID)
+		require.Equal(t, "test", job.Name)
+		require.Equal(t, model.JSON(`{"foo":"bar"}`), job.Payload)
+		require.Equal(t, 1, job.Attempts)
+		require.Equal(t, 3, job.MaxAttempts)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(time.Minute)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 2, job.Attempts)
+	})
+
+	t.Run("uses the lease instead of the timeout if there is one", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Hour, sql.WithLease(10*time.Second))
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		c.Advance(5 * time.Second)
+		received, err := q.ExtendJobLease(context.Background(), job.ID, job.Received.T)
+		require.NoError(t, err)
+		require.NotNil(t, received)
+
+		c.Advance(5 * time.Second)
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(5 * time.Second)
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("doesn't extend the lease of a job received again since", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Hour, sql.WithLease(10*time.Second))
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		c.Advance(10 * time.Second)
+		again, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, again)
+
+		received, err := q.ExtendJobLease(context.Background(), job.ID, job.Received.T)
+		require.NoError(t, err)
+		require.Nil(t, received)
+	})
+
+	t.Run("makes the job visible again after a timeout with fractional seconds", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{}, 1500*time.Millisecond)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		c.Advance(time.Second)
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(500 * time.Millisecond)
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("gets a job created for later within the same millisecond, like the database", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		_, err := q.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, 500*time.Microsecond)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("doesn't get a job created for later until its run time", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(time.Hour)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("gets jobs from the given queue, with higher priority first, then oldest first", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		for _, name := range []string{"low", "high", "low2"} {
+			var opts []sql.JobOption
+			if name == "high" {
+				opts = append(opts, sql.WithPriority(1))
+			}
+			_, err := q.CreateJob(context.Background(), name, model.Map{}, time.Minute, opts...)
+			require.NoError(t, err)
+		}
+		_, err := q.CreateJob(context.Background(), "other", model.Map{}, time.Minute, sql.WithQueue("other"))
+		require.NoError(t, err)
+
+		var names []string
+		for {
+			job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+			require.NoError(t, err)
+			if job == nil {
+				break
+			}
+			names = append(names, job.Name)
+		}
+		require.Equal(t, []string{"high", "low", "low2"}, names)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{Queue: "other"})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "other", job.Name)
+	})
+
+	t.Run("gets a job only after its dependencies have been deleted", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		aID, err := q.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = q.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "a", job.Name)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		err = q.DeleteJob(context.Background(), aID)
+		require.NoError(t, err)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "b", job.Name)
+	})
+
+	t.Run("dedupes keys per name among pending jobs only", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		id1, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("user-1"))
+		require.NoError(t, err)
+		id2, err := q.CreateJob(context.Background(), "other", model.Map{}, time.Minute, sql.WithKey("user-1"))
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id2)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, id1, job.ID)
+
+		id3, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("user-1"))
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id3)
+
+		err = q.RetryJob(context.Background(), id1, 0)
+		require.NoError(t, err)
+
+		jobs, err := q.GetJobs(context.Background(), sql.GetJobsOptions{Name: "test", State: model.JobStatePending})
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, id3, jobs[0].ID)
+	})
+
+	t.Run("errors if a dependency has already failed", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		aID, err := q.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		err = q.FailJob(context.Background(), aID, "oh no")
+		require.NoError(t, err)
+
+		_, err = q.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.Error(t, err)
+	})
+
+	t.Run("doesn't get jobs over the rate limit", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		for i := 0; i < 2; i++ {
+			_, err := q.CreateJob(context.Background(), "send-email", model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 1, Per: time.Second}}}
+
+		job, err := q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		job, err = q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(time.Second)
+
+		job, err = q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("doesn't count released jobs against the rate limit", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		for i := 0; i < 2; i++ {
+			_, err := q.CreateJob(context.Background(), "send-email", model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 1, Per: time.Hour}}}
+
+		job, err := q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		err = q.ReleaseJob(context.Background(), job.ID)
+		require.NoError(t, err)
+
+		job, err = q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		job, err = q.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestMemoryQueue_QuarantineJobs(t *testing.T) {
+	t.Run("quarantines jobs with unknown names that have been due for a while", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJob(context.Background(), "unknown", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = q.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{Names: []string{"test"}})
+		require.NoError(t, err)
+		require.Equal(t, "test", job.Name)
+
+		count, err := q.QuarantineJobs(context.Background(), sql.QuarantineJobsOptions{
+			After:  time.Hour,
+			Names:  []string{"test"},
+			Queues: []string{sql.DefaultQueue},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		c.Advance(2 * time.Hour)
+
+		count, err = q.QuarantineJobs(context.Background(), sql.QuarantineJobsOptions{
+			After:  time.Hour,
+			Names:  []string{"test"},
+			Queues: []string{sql.DefaultQueue},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		failedJobs, err := q.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+		require.Equal(t, "unknown", failedJobs[0].Name)
+		require.True(t, failedJobs[0].Quarantined)
+	})
+}
+
+func TestMemoryQueue_CreateJob(t *testing.T) {
+	t.Run("returns the existing job ID if a job with the same key exists", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		id1, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		id2, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+		require.Equal(t, id1, id2)
+	})
+}
+
+func TestMemoryQueue_RetryJob(t *testing.T) {
+	t.Run("makes a job available again after the delay", func(t *testing.T) {
+		q, c := newMemoryQueue()
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Hour)
+		require.NoError(t, err)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+
+		err = q.RetryJob(context.Background(), job.ID, time.Minute)
+		require.NoError(t, err)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		c.Advance(time.Minute)
+
+		job, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 2, job.Attempts)
+	})
+}
+
+func TestMemoryQueue_FailJob(t *testing.T) {
+	t.Run("moves a job and its dependents to the failed jobs", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		aID, err := q.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		bID, err := q.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+		_, err = q.CreateJob(context.Background(), "c", model.Map{}, time.Minute, sql.WithDependencies(bID))
+		require.NoError(t, err)
+
+		err = q.FailJob(context.Background(), aID, "oh no")
+		require.NoError(t, err)
+
+		failedJobs, err := q.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 3)
+		require.Equal(t, "c", failedJobs[0].Name)
+		require.Equal(t, "dependency 1 failed: oh no", failedJobs[0].Error)
+		require.Equal(t, "a", failedJobs[2].Name)
+		require.Equal(t, "oh no", failedJobs[2].Error)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestMemoryQueue_CancelJobsByName(t *testing.T) {
+	t.Run("deletes all jobs with the name and their dependents", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		aID, err := q.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = q.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+		_, err = q.CreateJob(context.Background(), "other", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		count, err := q.CancelJobsByName(context.Background(), "a")
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "other", job.Name)
+	})
+}
+
+func TestMemoryQueue_Runner(t *testing.T) {
+	t.Run("runs jobs and scheduled jobs with the runner", func(t *testing.T) {
+		q := jobs.NewMemoryQueue(jobs.NewMemoryQueueOptions{})
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        q,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var ran []string
+		runner.RegisterSchedule("scheduled", jobs.Every(time.Hour), time.Second, func(ctx context.Context, m model.Map) error {
+			return nil
+		})
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			ran = append(ran, m["foo"])
+			cancel()
+			return nil
+		})
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Equal(t, []string{"bar"}, ran)
+
+		// Only the scheduled job is left
+		job, err := q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestMemoryQueue_GetJobStatus(t *testing.T) {
+	t.Run("returns progress and result of a job, also after it has failed", func(t *testing.T) {
+		q, _ := newMemoryQueue()
+
+		id, err := q.CreateJob(context.Background(), "export", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		_, err = q.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+
+		err = q.UpdateJobProgress(context.Background(), id, 50, "Exporting users")
+		require.NoError(t, err)
+		err = q.SetJobResult(context.Background(), id, "partial")
+		require.NoError(t, err)
+
+		s, err := q.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.Equal(t, model.JobStateRunning, s.State)
+		require.Equal(t, 50, s.Progress)
+		require.Equal(t, model.JSON(`"partial"`), s.Result)
+
+		err = q.FailJob(context.Background(), id, "oh no")
+		require.NoError(t, err)
+
+		s, err = q.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.Equal(t, model.JobStateFailed, s.State)
+		require.Equal(t, "Exporting users", s.ProgressMessage)
+		require.Equal(t, "oh no", s.Error)
+	})
+}
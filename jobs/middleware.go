@@ -0,0 +1,50 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/honeybadger-io/honeybadger-go"
+
+	"github.com/maragudk/service/model"
+)
+
+// Handler runs a received job.
+type Handler = func(ctx context.Context, j model.Job) error
+
+// Middleware is an alias for a function that takes a Handler and returns one, too.
+type Middleware = func(Handler) Handler
+
+// Use middlewares around every job run. Middlewares are called in the order they are given, for all jobs,
+// with the first one being outermost. Call Use before Start.
+func (r *Runner) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// handle the job by wrapping the registered handler with all middlewares.
+func (r *Runner) handle(ctx context.Context, j model.Job, h payloadHandler) error {
+	next := func(ctx context.Context, j model.Job) error {
+		return h(ctx, j.Payload)
+	}
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		next = r.middlewares[i](next)
+	}
+	return next(ctx, j)
+}
+
+// Honeybadger reports panics in jobs to Honeybadger and then re-panics, like honeybadger.Handler does for HTTP.
+func Honeybadger(next Handler) Handler {
+	return func(ctx context.Context, j model.Job) error {
+		defer func() {
+			if err := recover(); err != nil {
+				_, _ = honeybadger.Notify(err, honeybadger.Context{
+					"job_id":   j.ID,
+					"job_name": j.Name,
+					"queue":    j.Queue,
+					"attempts": j.Attempts,
+				})
+				panic(err)
+			}
+		}()
+		return next(ctx, j)
+	}
+}
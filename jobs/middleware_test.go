@@ -0,0 +1,104 @@
+package jobs_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/honeybadger-io/honeybadger-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestRunner_Use(t *testing.T) {
+	t.Run("calls middlewares around jobs in order", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var calls []string
+		middleware := func(name string) jobs.Middleware {
+			return func(next jobs.Handler) jobs.Handler {
+				return func(ctx context.Context, j model.Job) error {
+					calls = append(calls, name+" before "+j.Name)
+					err := next(ctx, j)
+					calls = append(calls, name+" after "+j.Name)
+					return err
+				}
+			}
+		}
+		runner.Use(middleware("first"), middleware("second"))
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			calls = append(calls, "job")
+			cancel()
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Equal(t, []string{"first before test", "second before test", "job", "second after test", "first after test"}, calls)
+	})
+}
+
+func TestHoneybadger(t *testing.T) {
+	t.Run("reports panics in jobs", func(t *testing.T) {
+		backend := &honeybadgerBackendMock{}
+		honeybadger.Configure(honeybadger.Configuration{Backend: backend, Sync: true})
+		t.Cleanup(func() {
+			honeybadger.Configure(honeybadger.Configuration{Backend: honeybadger.NewNullBackend(), Sync: false})
+		})
+
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+		runner.Use(jobs.Honeybadger)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			panic("oh no")
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		backend.lock.Lock()
+		defer backend.lock.Unlock()
+		require.Len(t, backend.notices, 1)
+		require.Equal(t, "oh no", backend.notices[0].ErrorMessage)
+		require.Equal(t, "test", backend.notices[0].Context["job_name"])
+	})
+}
+
+type honeybadgerBackendMock struct {
+	lock    sync.Mutex
+	notices []*honeybadger.Notice
+}
+
+func (b *honeybadgerBackendMock) Notify(_ honeybadger.Feature, payload honeybadger.Payload) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if n, ok := payload.(*honeybadger.Notice); ok {
+		b.notices = append(b.notices, n)
+	}
+	return nil
+}
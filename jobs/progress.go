@@ -0,0 +1,58 @@
+package jobs
+
+import (
+	"context"
+
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+)
+
+// progressReporter is a queue that can store the progress and result of running jobs.
+type progressReporter interface {
+	UpdateJobProgress(ctx context.Context, id, progress int, message string) error
+	SetJobResult(ctx context.Context, id int, result any) error
+}
+
+type progressContextKey struct{}
+
+// jobProgress is put on the context of a running job, so the job can report to the queue.
+type jobProgress struct {
+	id int
+	q  progressReporter
+}
+
+// withProgress puts the job on the context for ReportProgress and ReportResult, if the queue supports it.
+func (r *Runner) withProgress(ctx context.Context, j *model.Job) context.Context {
+	p, ok := r.queue.(progressReporter)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, progressContextKey{}, jobProgress{id: j.ID, q: p})
+}
+
+// ReportProgress of the running job with the given context, in percent from 0 to 100,
+// with a message about what it's doing. The progress can be polled with sql.Database.GetJobStatus.
+// Does nothing if the context is not from a job run by a Runner with a queue that stores progress.
+// Returns an error if percent is outside 0 to 100, so a bug in calculating it doesn't crash the job.
+func ReportProgress(ctx context.Context, percent int, message string) error {
+	if percent < 0 || percent > 100 {
+		return errors.Newf("progress must be between 0 and 100 percent, got %v", percent)
+	}
+	p, ok := ctx.Value(progressContextKey{}).(jobProgress)
+	if !ok {
+		return nil
+	}
+	return p.q.UpdateJobProgress(ctx, p.id, percent, message)
+}
+
+// ReportResult of the running job with the given context, which is encoded as JSON.
+// Like ReportProgress, the result can be polled with sql.Database.GetJobStatus, also after the job has finished.
+// Does nothing if the context is not from a job run by a Runner with a queue that stores results.
+func ReportResult(ctx context.Context, result any) error {
+	p, ok := ctx.Value(progressContextKey{}).(jobProgress)
+	if !ok {
+		return nil
+	}
+	return p.q.SetJobResult(ctx, p.id, result)
+}
@@ -0,0 +1,80 @@
+package jobs_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestReportProgress(t *testing.T) {
+	t.Run("stores progress and result of a running job, kept after it has completed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		id, err := db.CreateJob(context.Background(), "export", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		var running *model.JobStatus
+		runner.Register("export", func(ctx context.Context, m model.Map) error {
+			defer cancel()
+
+			if err := jobs.ReportProgress(ctx, 50, "Exporting users"); err != nil {
+				return err
+			}
+
+			var err error
+			running, err = db.GetJobStatus(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			if err := jobs.ReportProgress(ctx, 100, "Done"); err != nil {
+				return err
+			}
+			return jobs.ReportResult(ctx, model.Map{"url": "https://example.com/export.csv"})
+		})
+
+		runner.Start(ctx)
+
+		require.NotNil(t, running)
+		require.Equal(t, model.JobStateRunning, running.State)
+		require.Equal(t, 50, running.Progress)
+		require.Equal(t, "Exporting users", running.ProgressMessage)
+
+		s, err := db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+		require.Equal(t, model.JobStateCompleted, s.State)
+		require.Equal(t, 100, s.Progress)
+		require.Equal(t, "Done", s.ProgressMessage)
+		require.Equal(t, model.JSON(`{"url":"https://example.com/export.csv"}`), s.Result)
+	})
+
+	t.Run("does nothing outside a job", func(t *testing.T) {
+		err := jobs.ReportProgress(context.Background(), 50, "")
+		require.NoError(t, err)
+
+		err = jobs.ReportResult(context.Background(), "result")
+		require.NoError(t, err)
+	})
+
+	t.Run("errors if the progress is not a percentage", func(t *testing.T) {
+		err := jobs.ReportProgress(context.Background(), 101, "")
+		require.Error(t, err)
+
+		err = jobs.ReportProgress(context.Background(), -1, "")
+		require.Error(t, err)
+	})
+}
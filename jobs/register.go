@@ -2,4 +2,8 @@ package jobs
 
 func (r *Runner) registerJobs() {
 	Health(r)
+
+	if r.history != nil {
+		CleanupJobRuns(r, r.history, r.historyRetention, r.now)
+	}
 }
@@ -5,11 +5,14 @@ import (
 	"context"
 	"io"
 	"log"
+	"math/rand"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/maragudk/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 
@@ -20,33 +23,90 @@ import (
 
 // Runner runs jobs.
 type Runner struct {
-	currentJobCount     int
-	currentJobCountLock sync.RWMutex
-	database            *sql.Database
-	emailSender         *email.Sender
-	jobCount            *prometheus.CounterVec
-	jobDuration         *prometheus.CounterVec
-	jobCountLimit       int
-	jobs                map[string]Func
-	log                 *log.Logger
-	pollInterval        time.Duration
-	queue               queue
-	runnerReceives      *prometheus.CounterVec
+	database         *sql.Database
+	drainTimeout     time.Duration
+	emailSender      *email.Sender
+	history          historyStore
+	historyRetention time.Duration
+	jobCount         *prometheus.CounterVec
+	jobDuration      *prometheus.CounterVec
+	jobDurations     *prometheus.HistogramVec
+	jobQueues        []*jobQueue
+	jobs             map[string]payloadHandler
+	leadSeparately   bool
+	log              *log.Logger
+	maxRetryDelay    time.Duration
+	middlewares      []Middleware
+	names            []string
+	now              func() time.Time
+	quarantineAfter  time.Duration
+	queue            Queue
+	rateLimits       []sql.RateLimit
+	retryDelay       time.Duration
+	runnerReceives   *prometheus.CounterVec
+	running          map[int]*runningJob
+	runningGauge     *prometheus.GaugeVec
+	runningLock      sync.Mutex
+	schedules        map[string]scheduledJob
+	setupOnce        sync.Once
 }
 
+// NewRunnerOptions for NewRunner.
+// JobLimit and PollInterval are for the default queue. Other named queues are set up with Queues.
+// If History is true, every job run is recorded in the queue, which must support it, and runs older than
+// HistoryRetention are cleaned up by a scheduled job. HistoryRetention defaults to 30 days.
+// DrainTimeout is how long running jobs get to finish after the runner is stopped, before they're cancelled and
+// released back to the queue. It defaults to 0, which waits for running jobs to finish without a deadline.
+// The runner only receives jobs with registered names. Jobs in this runner's queues with names this runner hasn't
+// registered are quarantined in the failed jobs after being due for QuarantineAfter, if the queue supports it.
+// QuarantineAfter defaults to one hour. Nothing is quarantined if this runner has no registered jobs.
+// Now is used to get the current time for schedules, and defaults to time.Now.
+// If LeadSeparately is true, Start only receives and runs jobs, and Lead must be called to schedule jobs and
+// quarantine them, so that only one of several runners sharing the queue does it, see Lead.
 type NewRunnerOptions struct {
-	Database     *sql.Database
-	EmailSender  *email.Sender
+	Database         *sql.Database
+	DrainTimeout     time.Duration
+	EmailSender      *email.Sender
+	History          bool
+	HistoryRetention time.Duration
+	JobLimit         int
+	LeadSeparately   bool
+	Log              *log.Logger
+	MaxRetryDelay    time.Duration
+	Metrics          *prometheus.Registry
+	Now              func() time.Time
+	PollInterval     time.Duration
+	QuarantineAfter  time.Duration
+	Queue            Queue
+	Queues           []QueueOptions
+	RetryDelay       time.Duration
+}
+
+// QueueOptions for a named queue, each with their own concurrency limit and poll interval.
+// JobLimit defaults to 1, and PollInterval to one second.
+type QueueOptions struct {
+	Name         string
 	JobLimit     int
-	Log          *log.Logger
-	Metrics      *prometheus.Registry
 	PollInterval time.Duration
-	Queue        queue
 }
 
-type queue interface {
+// Queue of jobs for the Runner. sql.Database and MemoryQueue are implementations.
+// Receiving a job with GetJob makes it invisible to other calls to GetJob until its timeout, or lease if it has one.
+// Other features, like scheduled jobs and cancelling, are used if the queue also supports them.
+type Queue interface {
 	DeleteJob(ctx context.Context, id int) error
-	GetJob(ctx context.Context) (*model.Job, error)
+	FailJob(ctx context.Context, id int, reason string) error
+	GetJob(ctx context.Context, opts sql.GetJobOptions) (*model.Job, error)
+	RetryJob(ctx context.Context, id int, after time.Duration) error
+}
+
+// jobQueue keeps track of the running jobs in a named queue.
+type jobQueue struct {
+	currentJobCount     int
+	currentJobCountLock sync.RWMutex
+	jobCountLimit       int
+	name                string
+	pollInterval        time.Duration
 }
 
 func NewRunner(opts NewRunnerOptions) *Runner {
@@ -58,17 +118,54 @@ func NewRunner(opts NewRunnerOptions) *Runner {
 		opts.Metrics = prometheus.NewRegistry()
 	}
 
-	if opts.JobLimit == 0 {
-		opts.JobLimit = 1
+	jobQueues := []*jobQueue{newJobQueue(QueueOptions{
+		Name:         sql.DefaultQueue,
+		JobLimit:     opts.JobLimit,
+		PollInterval: opts.PollInterval,
+	})}
+	for _, q := range opts.Queues {
+		if q.Name == "" {
+			panic("queue name cannot be empty")
+		}
+		for _, existing := range jobQueues {
+			if existing.name == q.Name {
+				panic("there is already a queue with this name: " + q.Name)
+			}
+		}
+		jobQueues = append(jobQueues, newJobQueue(q))
 	}
 
-	if opts.PollInterval == 0 {
-		opts.PollInterval = time.Second
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	if opts.MaxRetryDelay == 0 {
+		opts.MaxRetryDelay = time.Hour
+	}
+
+	var history historyStore
+	if opts.History {
+		var ok bool
+		if history, ok = opts.Queue.(historyStore); !ok {
+			panic("queue does not support job history")
+		}
+	}
+
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	if opts.QuarantineAfter == 0 {
+		opts.QuarantineAfter = time.Hour
+	}
+
+	if opts.HistoryRetention == 0 {
+		opts.HistoryRetention = 30 * 24 * time.Hour
 	}
 
 	jobCount := promauto.With(opts.Metrics).NewCounterVec(prometheus.CounterOpts{
 		Name: "app_jobs_total",
-	}, []string{"name", "success"})
+	}, []string{"name", "queue", "success"})
 
 	jobDuration := promauto.With(opts.Metrics).NewCounterVec(prometheus.CounterOpts{
 		Name: "app_job_duration_seconds_total",
@@ -78,145 +175,821 @@ func NewRunner(opts NewRunnerOptions) *Runner {
 		Name: "app_job_runner_receives_total",
 	}, []string{"success"})
 
+	jobDurations := promauto.With(opts.Metrics).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "app_job_run_duration_seconds",
+		Help:    "Job durations.",
+		Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"name", "success"})
+
+	runningGauge := promauto.With(opts.Metrics).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "app_jobs_running",
+		Help: "The number of currently running jobs.",
+	}, []string{"name"})
+
 	return &Runner{
-		database:       opts.Database,
-		emailSender:    opts.EmailSender,
-		jobCount:       jobCount,
-		jobDuration:    jobDuration,
-		jobCountLimit:  opts.JobLimit,
-		jobs:           map[string]Func{},
-		log:            opts.Log,
-		pollInterval:   opts.PollInterval,
-		queue:          opts.Queue,
-		runnerReceives: runnerReceives,
+		database:         opts.Database,
+		drainTimeout:     opts.DrainTimeout,
+		emailSender:      opts.EmailSender,
+		history:          history,
+		historyRetention: opts.HistoryRetention,
+		jobCount:         jobCount,
+		jobDuration:      jobDuration,
+		jobDurations:     jobDurations,
+		jobQueues:        jobQueues,
+		jobs:             map[string]payloadHandler{},
+		leadSeparately:   opts.LeadSeparately,
+		log:              opts.Log,
+		maxRetryDelay:    opts.MaxRetryDelay,
+		now:              opts.Now,
+		quarantineAfter:  opts.QuarantineAfter,
+		queue:            opts.Queue,
+		retryDelay:       opts.RetryDelay,
+		runnerReceives:   runnerReceives,
+		running:          map[int]*runningJob{},
+		runningGauge:     runningGauge,
+		schedules:        map[string]scheduledJob{},
+	}
+}
+
+func newJobQueue(opts QueueOptions) *jobQueue {
+	if opts.JobLimit == 0 {
+		opts.JobLimit = 1
+	}
+
+	if opts.PollInterval == 0 {
+		opts.PollInterval = time.Second
+	}
+
+	return &jobQueue{
+		jobCountLimit: opts.JobLimit,
+		name:          opts.Name,
+		pollInterval:  opts.PollInterval,
 	}
 }
 
 // Func is the actual work to do in a job.
-// The given context is the root context of the runner, which may be cancelled.
-// It also has a timeout.
+// The given context has the job timeout, and is also cancelled if the runner is stopped and
+// the drain timeout passes before the job has finished. Use it with ReportProgress and ReportResult.
 type Func = func(context.Context, model.Map) error
 
-// Start the Runner, blocking until the given context is cancelled.
+// payloadHandler for a job, given its raw payload. See Register and RegisterTyped.
+type payloadHandler = func(ctx context.Context, payload model.JSON) error
+
+// Start the Runner, blocking until the given context is cancelled and running jobs are drained.
+// Unless the runner leads separately, see NewRunnerOptions, it also does what Lead does.
+// It can be started again after it has stopped.
 func (r *Runner) Start(ctx context.Context) {
 	r.log.Println("Starting")
-	r.registerJobs()
+	r.setup()
+	r.logUnknownQueues(ctx)
+	if !r.leadSeparately {
+		r.scheduleJobs(ctx)
+	}
+
+	// Jobs run with their own context, so they can keep running while draining after ctx is cancelled
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	// pollWG is for the polling of each queue, jobWG for the running jobs
+	var pollWG, jobWG sync.WaitGroup
 
-	var names []string
-	for k := range r.jobs {
-		names = append(names, k)
+	for _, q := range r.jobQueues {
+		pollWG.Add(1)
+		go func(q *jobQueue) {
+			defer pollWG.Done()
+			r.poll(ctx, jobsCtx, q, &jobWG)
+		}(q)
 	}
-	sort.Strings(names)
 
-	r.log.Println("Registered jobs:", names)
+	if qr, ok := r.queue.(quarantiner); ok && !r.leadSeparately {
+		pollWG.Add(1)
+		go func() {
+			defer pollWG.Done()
+			r.quarantineUntilStopped(ctx, qr)
+		}()
+	}
 
-	var wg sync.WaitGroup
+	<-ctx.Done()
+	r.log.Println("Stopping")
+	pollWG.Wait()
+	r.drain(&jobWG, cancelJobs)
+	r.log.Println("Stopped")
+}
+
+// Lead by doing the work that only one of several runners sharing the queue needs to do, blocking until the given
+// context is cancelled. It stores the scheduled jobs in the queue, which includes cleaning up job runs if History is
+// enabled, and periodically quarantines jobs with unknown names.
+// Only use it with NewRunnerOptions.LeadSeparately, for example while holding a lease with sql.Database.Lead,
+// and call Start on all runners to receive and run jobs. It can be called again after it has returned.
+func (r *Runner) Lead(ctx context.Context) {
+	r.log.Println("Leading")
+	r.setup()
+	r.scheduleJobs(ctx)
+
+	if qr, ok := r.queue.(quarantiner); ok {
+		r.quarantineUntilStopped(ctx, qr)
+	} else {
+		<-ctx.Done()
+	}
+	r.log.Println("Stopped leading")
+}
+
+// setup the Runner the first time it's started or run, registering built-in jobs.
+func (r *Runner) setup() {
+	r.setupOnce.Do(func() {
+		r.registerJobs()
+
+		var names []string
+		for k := range r.jobs {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		r.log.Println("Registered jobs:", names)
+		r.names = names
+	})
+}
+
+// RunDue jobs synchronously, one at a time, until no more jobs in any of the queues are due to run.
+// Returns how many jobs were run. Errors from the jobs themselves are handled like with Start, so they're retried or
+// failed in the queue, and only errors receiving jobs are returned.
+// It's meant for tests instead of Start, see the jobstest package.
+func (r *Runner) RunDue(ctx context.Context) (int, error) {
+	r.setup()
+	r.scheduleJobs(ctx)
+
+	var count int
+	for {
+		var receivedAny bool
+		for _, q := range r.jobQueues {
+			j, job, received, err := r.receive(ctx, q.name)
+			if err != nil {
+				return count, err
+			}
+			receivedAny = receivedAny || received
+			if job == nil {
+				continue
+			}
+			r.run(ctx, j, job)
+			count++
+		}
+		if !receivedAny {
+			return count, nil
+		}
+	}
+}
+
+// drain running jobs by waiting for them to finish, for at most the drain timeout if there is one.
+// After that, running jobs are cancelled, and released back to the queue if it supports it.
+func (r *Runner) drain(wg *sync.WaitGroup, cancelJobs context.CancelFunc) {
+	if r.drainTimeout <= 0 {
+		wg.Wait()
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(r.drainTimeout)
+	defer timer.Stop()
 
-	ticker := time.NewTicker(r.pollInterval)
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+		r.log.Println("Drain timeout passed, cancelling running jobs")
+	}
+
+	cancelJobs()
+	<-done
+}
+
+// queueLister is a queue that can list the queues that have jobs in them.
+type queueLister interface {
+	GetJobQueues(ctx context.Context) ([]string, error)
+}
+
+// logUnknownQueues that have jobs in them but aren't in this runner, since those jobs are never received here.
+// Other runners may serve these queues, so it's only logged.
+func (r *Runner) logUnknownQueues(ctx context.Context) {
+	ql, ok := r.queue.(queueLister)
+	if !ok {
+		return
+	}
+
+	queues, err := ql.GetJobQueues(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.log.Println("Error getting job queues:", err)
+		}
+		return
+	}
+
+	var unknown []string
+	for _, name := range queues {
+		if !r.hasQueue(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		r.log.Println("Jobs in queues that this runner doesn't have, which it won't receive:", strings.Join(unknown, ", "))
+	}
+}
+
+// quarantiner is a queue that can quarantine jobs with names that aren't registered.
+type quarantiner interface {
+	QuarantineJobs(ctx context.Context, opts sql.QuarantineJobsOptions) (int, error)
+}
+
+// quarantineUntilStopped periodically quarantines jobs in this runner's queues that have been due for longer than the
+// quarantine duration, but have a name this runner hasn't registered, until the context is cancelled.
+// Other runners may have these jobs registered, for example during a deploy, which is why they get some time first.
+// Runners sharing a queue must all register the same names, since they would otherwise quarantine each other's jobs.
+func (r *Runner) quarantineUntilStopped(ctx context.Context, qr quarantiner) {
+	var queues []string
+	for _, q := range r.jobQueues {
+		queues = append(queues, q.name)
+	}
+
+	interval := time.Minute
+	if r.quarantineAfter < interval {
+		interval = r.quarantineAfter
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			r.log.Println("Stopping")
-			ticker.Stop()
-			wg.Wait()
-			r.log.Println("Stopped")
 			return
 		case <-ticker.C:
-			r.receiveAndRun(ctx, &wg)
+			if primary, err := r.isPrimary(); err != nil || !primary {
+				continue
+			}
+			if len(r.names) == 0 {
+				continue
+			}
+			n, err := qr.QuarantineJobs(ctx, sql.QuarantineJobsOptions{
+				After:  r.quarantineAfter,
+				Names:  r.names,
+				Queues: queues,
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					r.log.Println("Error quarantining jobs:", err)
+				}
+				continue
+			}
+			if n > 0 {
+				r.log.Println("Quarantined jobs with names that aren't registered:", n)
+			}
 		}
 	}
 }
 
-// receiveAndRun jobs.
-func (r *Runner) receiveAndRun(ctx context.Context, wg *sync.WaitGroup) {
-	r.currentJobCountLock.RLock()
-	if r.currentJobCount == r.jobCountLimit {
-		r.currentJobCountLock.RUnlock()
-		return
+// releaser is a queue that can release received jobs, making them available again immediately.
+type releaser interface {
+	ReleaseJob(ctx context.Context, id int) error
+}
+
+// poll the given queue for jobs until the context is cancelled.
+// Polling happens at the poll interval, and also immediately when the queue notifies about created jobs,
+// if it supports it.
+func (r *Runner) poll(ctx, jobsCtx context.Context, q *jobQueue, wg *sync.WaitGroup) {
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	var jobCreated <-chan struct{}
+	if n, ok := r.queue.(notifier); ok {
+		var unsubscribe func()
+		jobCreated, unsubscribe = n.SubscribeJobCreated()
+		defer unsubscribe()
+	}
+
+	r.receiveAndRunAll(ctx, jobsCtx, q, wg)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.receiveAndRunAll(ctx, jobsCtx, q, wg)
+		case <-jobCreated:
+			r.receiveAndRunAll(ctx, jobsCtx, q, wg)
+		}
+	}
+}
+
+// notifier is a queue that can notify about created jobs.
+type notifier interface {
+	SubscribeJobCreated() (<-chan struct{}, func())
+}
+
+// receiveAndRunAll jobs from the given queue, until there are no more jobs or the job limit is reached.
+func (r *Runner) receiveAndRunAll(ctx, jobsCtx context.Context, q *jobQueue, wg *sync.WaitGroup) {
+	for ctx.Err() == nil && r.receiveAndRun(ctx, jobsCtx, q, wg) {
+	}
+}
+
+// receiveAndRun a job from the given queue. Returns whether a job was received.
+// The job is run with a context derived from jobsCtx, which outlives ctx while draining.
+func (r *Runner) receiveAndRun(ctx, jobsCtx context.Context, q *jobQueue, wg *sync.WaitGroup) bool {
+	q.currentJobCountLock.RLock()
+	if q.currentJobCount == q.jobCountLimit {
+		q.currentJobCountLock.RUnlock()
+		return false
 	} else {
-		r.currentJobCountLock.RUnlock()
+		q.currentJobCountLock.RUnlock()
 	}
 
-	j, err := r.queue.GetJob(ctx)
+	j, job, received, err := r.receive(ctx, q.name)
 	if err != nil {
-		r.runnerReceives.WithLabelValues("false").Inc()
+		if ctx.Err() != nil {
+			return false
+		}
 		// Sleep a bit to not hammer the queue if there's an error with it
-		time.Sleep(time.Second)
-		return
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+		return false
+	}
+
+	if job == nil {
+		return received
+	}
+
+	q.currentJobCountLock.Lock()
+	q.currentJobCount++
+	q.currentJobCountLock.Unlock()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		defer func() {
+			q.currentJobCountLock.Lock()
+			q.currentJobCount--
+			q.currentJobCountLock.Unlock()
+		}()
+
+		r.run(jobsCtx, j, job)
+	}()
+
+	return true
+}
+
+// primaryChecker is a queue that can only be written to on the primary, like a replicated database.
+type primaryChecker interface {
+	IsPrimary() (bool, string, error)
+}
+
+// isPrimary if the queue can be written to here. Queues without replicas are always primary.
+func (r *Runner) isPrimary() (bool, error) {
+	p, ok := r.queue.(primaryChecker)
+	if !ok {
+		return true, nil
+	}
+	primary, _, err := p.IsPrimary()
+	return primary, err
+}
+
+// receive a job from the named queue. Returns the job with its handler if it should be run,
+// and whether a job was received at all. Received jobs that shouldn't be run are handed back or failed.
+// Jobs are only received on the primary, since receiving writes to the queue.
+func (r *Runner) receive(ctx context.Context, queue string) (*model.Job, payloadHandler, bool, error) {
+	if primary, err := r.isPrimary(); err != nil || !primary {
+		return nil, nil, false, err
+	}
+
+	j, err := r.queue.GetJob(ctx, sql.GetJobOptions{Names: r.names, Queue: queue, RateLimits: r.rateLimits})
+	if err != nil {
+		if ctx.Err() == nil {
+			r.runnerReceives.WithLabelValues("false").Inc()
+		}
+		return nil, nil, false, err
 	}
 
 	// If there was no job there is nothing to do
 	if j == nil {
 		r.runnerReceives.WithLabelValues("true").Inc()
-		return
+		return nil, nil, false, nil
 	}
 
+	// Only registered names are received, but give the job back right away if the queue received another one anyway
 	job, ok := r.jobs[j.Name]
 	if !ok {
 		r.runnerReceives.WithLabelValues("false").Inc()
 		r.log.Println("No job with this name:", j.Name)
-		return
+		if rel, ok := r.queue.(releaser); ok {
+			if err := rel.ReleaseJob(ctx, j.ID); err != nil {
+				r.log.Println("Error releasing job:", err)
+			}
+		}
+		return nil, nil, true, nil
 	}
 
 	r.runnerReceives.WithLabelValues("true").Inc()
 
-	r.currentJobCountLock.Lock()
-	r.currentJobCount++
-	r.currentJobCountLock.Unlock()
+	// A job can be received more times than its max attempts if a runner stopped without finishing it,
+	// for example because the job crashed the process. Don't run it again.
+	if j.Attempts > j.MaxAttempts {
+		r.log.Println("Job exceeded max attempts without finishing, giving up:", j.Name)
+		if j.Schedule != "" {
+			r.rescheduleJob(ctx, j)
+			return nil, nil, true, nil
+		}
+		if err := r.queue.FailJob(ctx, j.ID, "exceeded max attempts without finishing"); err != nil {
+			r.log.Println("Error failing job:", err)
+		}
+		return nil, nil, true, nil
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	return j, job, true, nil
+}
 
-		defer func() {
-			r.currentJobCountLock.Lock()
-			r.currentJobCount--
-			r.currentJobCountLock.Unlock()
-		}()
+// run a received job, blocking until it has finished and been deleted, retried, failed or rescheduled in the queue.
+func (r *Runner) run(jobsCtx context.Context, j *model.Job, job payloadHandler) {
+	var before time.Time
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.jobCount.WithLabelValues(j.Name, j.Queue, "false").Inc()
+			r.log.Println("Recovered from panic in job:", rec)
+
+			// The job is left in the queue to be received again after its timeout, but the run is recorded as failed
+			queueCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			r.recordRun(queueCtx, j, before, time.Since(before), model.JobRunOutcomeFailure, errors.Newf("panic: %v", rec))
+		}
+	}()
 
-		defer func() {
-			if rec := recover(); rec != nil {
-				r.jobCount.WithLabelValues(j.Name, "false").Inc()
-				r.log.Println("Recovered from panic in job:", rec)
+	jobCtx, cancel := context.WithTimeout(jobsCtx, j.Timeout)
+	defer cancel()
+	jobCtx = r.withProgress(jobCtx, j)
+
+	r.addRunningJob(j, cancel)
+	defer r.removeRunningJob(j.ID)
+
+	stopWatching := r.watchUntilStopped(j)
+	defer stopWatching()
+
+	r.runningGauge.WithLabelValues(j.Name).Inc()
+	defer r.runningGauge.WithLabelValues(j.Name).Dec()
+
+	before = time.Now()
+	err := r.handle(jobCtx, *j, job)
+	duration := time.Since(before)
+	// Checked right away, since stopping the runner may cancel the jobs context any time after the job has returned
+	stopping := jobsCtx.Err() != nil
+
+	stopWatching()
+
+	success := strconv.FormatBool(err == nil)
+	r.jobCount.WithLabelValues(j.Name, j.Queue, success).Inc()
+	r.jobDuration.WithLabelValues(j.Name, success).Add(duration.Seconds())
+	r.jobDurations.WithLabelValues(j.Name, success).Observe(duration.Seconds())
+
+	// A cancelled job is already gone from the queue, so there's nothing more to do
+	if r.isCancelled(j.ID) {
+		r.log.Println("Job cancelled:", j.Name)
+		return
+	}
+
+	// We use context.Background as the parent context instead of the existing ctx, because if we've come
+	// this far we don't want the deletion or retry to be cancelled.
+	queueCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// A job interrupted because the runner stopped is handed back, so another runner can receive it right away
+	if err != nil && stopping {
+		if rel, ok := r.queue.(releaser); ok {
+			if err := rel.ReleaseJob(queueCtx, j.ID); err != nil {
+				r.log.Println("Error releasing job, it will be repeated after the timeout:", err)
+			} else {
+				r.log.Println("Job interrupted by stopping, released:", j.Name)
 			}
-		}()
+			return
+		}
+	}
 
-		jobCtx, cancel := context.WithTimeout(ctx, j.Timeout)
-		defer cancel()
+	outcome := model.JobRunOutcomeSuccess
+	if err != nil {
+		outcome = model.JobRunOutcomeFailure
+		if j.Attempts < j.MaxAttempts && !IsPermanent(err) {
+			outcome = model.JobRunOutcomeRetry
+		}
+	}
+	r.recordRun(queueCtx, j, before, duration, outcome, err)
 
-		before := time.Now()
-		err := job(jobCtx, j.Payload)
-		duration := time.Since(before)
+	if err != nil {
+		if outcome == model.JobRunOutcomeRetry {
+			delay := r.getRetryDelay(j.Attempts)
+			r.log.Println("Error running job, retrying in", delay, ":", err)
+			if err := r.queue.RetryJob(queueCtx, j.ID, delay); err != nil {
+				r.log.Println("Error retrying job, it will be repeated after the timeout:", err)
+			}
+			return
+		}
 
-		success := strconv.FormatBool(err == nil)
-		r.jobCount.WithLabelValues(j.Name, success).Inc()
-		r.jobDuration.WithLabelValues(j.Name, success).Add(duration.Seconds())
+		r.log.Println("Error running job, giving up after", j.Attempts, "attempt(s):", err)
 
-		if err != nil {
-			r.log.Println("Error running job:", err)
+		// Scheduled jobs are not failed, so they keep recurring
+		if j.Schedule == "" {
+			if err := r.queue.FailJob(queueCtx, j.ID, err.Error()); err != nil {
+				r.log.Println("Error failing job, it will be repeated:", err)
+			}
 			return
 		}
+	}
+
+	if j.Schedule != "" {
+		r.rescheduleJob(queueCtx, j)
+		return
+	}
+
+	if err := r.queue.DeleteJob(queueCtx, j.ID); err != nil {
+		r.log.Println("Error deleting job, it will be repeated:", err)
+	}
+}
+
+// rescheduleJob to the next time on its schedule.
+// If the job is no longer registered with a schedule, it is deleted.
+func (r *Runner) rescheduleJob(ctx context.Context, j *model.Job) {
+	sj, ok := r.schedules[j.Name]
+	s, isScheduler := r.queue.(scheduler)
+	if !ok || !isScheduler {
+		r.log.Println("Job is not registered with a schedule anymore, deleting it:", j.Name)
+		if err := r.queue.DeleteJob(ctx, j.ID); err != nil {
+			r.log.Println("Error deleting job, it will be repeated:", err)
+		}
+		return
+	}
 
-		// We use context.Background as the parent context instead of the existing ctx, because if we've come
-		// this far we don't want the deletion to be cancelled.
-		deleteCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := r.queue.DeleteJob(deleteCtx, j.ID); err != nil {
+	next := sj.schedule.Next(r.now())
+	// A zero time would make the job due right away, over and over
+	if next.IsZero() {
+		r.log.Println("Schedule has no next time, deleting job:", j.Name)
+		if err := r.queue.DeleteJob(ctx, j.ID); err != nil {
 			r.log.Println("Error deleting job, it will be repeated:", err)
 		}
+		return
+	}
+
+	if err := s.RescheduleJob(ctx, j.ID, next); err != nil {
+		r.log.Println("Error rescheduling job, it will be repeated:", err)
+	}
+}
+
+// leaseExtender is a queue that can extend the lease of received jobs, as long as they haven't been received again.
+type leaseExtender interface {
+	ExtendJobLease(ctx context.Context, id int, received time.Time) (*model.Time, error)
+}
+
+// cancelChecker is a queue that can check whether a received job has been cancelled, also by other processes.
+type cancelChecker interface {
+	IsJobCancelled(ctx context.Context, id int) (bool, error)
+}
+
+// watchUntilStopped the job periodically in the background, until the returned function is called.
+// It extends the lease of the job, if it has one, and checks whether the job has been cancelled in the queue,
+// for example by another process, every poll interval of its queue. A cancelled job is cancelled here as well.
+// If the lease has been lost because it expired and the job was received again, the job is also cancelled here,
+// since it's now running elsewhere.
+func (r *Runner) watchUntilStopped(j *model.Job) func() {
+	e, extend := r.queue.(leaseExtender)
+	extend = extend && j.Lease > 0 && j.Received != nil
+	c, check := r.queue.(cancelChecker)
+	if !extend && !check {
+		return func() {}
+	}
+
+	interval := r.getPollInterval(j.Queue)
+	if extend && j.Lease/3 < interval {
+		interval = j.Lease / 3
+	}
+	timeout := interval
+	if timeout < time.Second {
+		timeout = time.Second
+	}
+
+	var received time.Time
+	if extend {
+		received = j.Received.T
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				var lost bool
+				if extend {
+					if t, err := e.ExtendJobLease(ctx, j.ID, received); err != nil {
+						r.log.Println("Error extending job lease:", err)
+					} else if t == nil {
+						lost = true
+					} else {
+						received = t.T
+					}
+				}
+				var cancelled bool
+				if check {
+					var err error
+					if cancelled, err = c.IsJobCancelled(ctx, j.ID); err != nil {
+						r.log.Println("Error checking whether job is cancelled:", err)
+					}
+				}
+				cancel()
+
+				if cancelled {
+					r.log.Println("Job cancelled in the queue, stopping it:", j.Name)
+					r.cancelRunningJob(j.ID)
+					return
+				}
+				if lost {
+					r.log.Println("Job lease lost, stopping it:", j.Name)
+					r.cancelRunningJob(j.ID)
+					return
+				}
+			}
+		}
 	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// getPollInterval of the queue with the given name, or one second if the runner doesn't have the queue.
+func (r *Runner) getPollInterval(queue string) time.Duration {
+	for _, q := range r.jobQueues {
+		if q.name == queue {
+			return q.pollInterval
+		}
+	}
+	return time.Second
+}
+
+// getRetryDelay after the given number of attempts, using exponential backoff with jitter.
+// The delay doubles with each attempt, up to the max retry delay, and is then randomized between half and all of it.
+func (r *Runner) getRetryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := r.maxRetryDelay
+	if attempts < 32 {
+		if d := r.retryDelay << (attempts - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
 // registry provides a way to Register jobs by name.
 type registry interface {
-	Register(name string, fn Func)
+	Register(name string, fn Func, opts ...RegisterOption)
+	RegisterSchedule(name string, s Schedule, timeout time.Duration, fn Func, opts ...RegisterOption)
+	registerHandler(name string, h payloadHandler, opts ...RegisterOption)
+}
+
+// RegisterOption changes how a job is run. See Register.
+type RegisterOption func(*registerOptions)
+
+type registerOptions struct {
+	queue     string
+	rateLimit *sql.RateLimit
+}
+
+// WithScheduleQueue puts a job registered with RegisterSchedule in the queue with the given name, instead of the
+// default queue. The runner must have the queue, see NewRunnerOptions.Queues.
+// Other jobs get their queue when they're created, see sql.WithQueue, so it's only used by RegisterSchedule.
+func WithScheduleQueue(name string) RegisterOption {
+	if name == "" {
+		panic("queue name cannot be empty")
+	}
+	return func(o *registerOptions) {
+		o.queue = name
+	}
+}
+
+// WithRateLimit runs the job at most n times per interval, in addition to the job limit of its queue.
+// Jobs over the limit are left in the queue until the interval allows them to run.
+// The limit holds across all runners sharing the queue, as long as they register the job with the same limit.
+// The interval can be at most one day.
+func WithRateLimit(n int, per time.Duration) RegisterOption {
+	if n < 1 {
+		panic("rate limit must be at least 1")
+	}
+	if per <= 0 || per > sql.MaxRateLimitInterval {
+		panic("rate limit interval must be positive and at most one day")
+	}
+	return func(o *registerOptions) {
+		o.rateLimit = &sql.RateLimit{Limit: n, Per: per}
+	}
 }
 
 // Register job by name. Satisfies the registry interface.
-func (r *Runner) Register(name string, j Func) {
+// The payload is decoded into a model.Map before calling the job. See RegisterTyped for other payload types.
+func (r *Runner) Register(name string, j Func, opts ...RegisterOption) {
+	RegisterTyped(r, name, j, opts...)
+}
+
+func (r *Runner) registerHandler(name string, h payloadHandler, opts ...RegisterOption) {
 	if _, ok := r.jobs[name]; ok {
 		panic("there is already a job with this name: " + name)
 	}
-	r.jobs[name] = j
+
+	var o registerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r.jobs[name] = h
+	if o.rateLimit != nil {
+		o.rateLimit.Name = name
+		r.rateLimits = append(r.rateLimits, *o.rateLimit)
+	}
+}
+
+// scheduledJob is a job registered with RegisterSchedule.
+type scheduledJob struct {
+	queue    string
+	schedule Schedule
+	timeout  time.Duration
+}
+
+// scheduler is a queue that can store recurring jobs.
+type scheduler interface {
+	ScheduleJob(ctx context.Context, name, schedule string, timeout time.Duration, run time.Time, opts ...sql.JobOption) error
+	RescheduleJob(ctx context.Context, id int, run time.Time) error
+}
+
+// RegisterSchedule registers a job by name like Register, and makes it recur on the given Schedule.
+// The job is stored in the queue when the Runner starts, once per name no matter how many runners share the queue,
+// so each occurrence is only run once. It's put in the default queue, unless given WithScheduleQueue.
+func (r *Runner) RegisterSchedule(name string, s Schedule, timeout time.Duration, j Func, opts ...RegisterOption) {
+	var o registerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.queue == "" {
+		o.queue = sql.DefaultQueue
+	}
+	if !r.hasQueue(o.queue) {
+		panic("there is no queue with this name: " + o.queue)
+	}
+
+	r.Register(name, j, opts...)
+	r.schedules[name] = scheduledJob{queue: o.queue, schedule: s, timeout: timeout}
+}
+
+// hasQueue with the given name in this Runner.
+func (r *Runner) hasQueue(name string) bool {
+	for _, q := range r.jobQueues {
+		if q.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleJobs registered with RegisterSchedule in the queue.
+func (r *Runner) scheduleJobs(ctx context.Context) {
+	if len(r.schedules) == 0 {
+		return
+	}
+
+	s, ok := r.queue.(scheduler)
+	if !ok {
+		panic("queue does not support scheduled jobs")
+	}
+
+	for name, sj := range r.schedules {
+		next := sj.schedule.Next(r.now())
+		if next.IsZero() {
+			r.log.Println("Schedule has no next time, not scheduling job:", name)
+			continue
+		}
+		if err := s.ScheduleJob(ctx, name, sj.schedule.String(), sj.timeout, next, sql.WithQueue(sj.queue)); err != nil {
+			r.log.Println("Error scheduling job:", err)
+		}
+	}
 }
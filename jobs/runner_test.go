@@ -2,16 +2,21 @@ package jobs_test
 
 import (
 	"context"
+	"errors"
 	"log"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/jobstest"
 	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
 	"github.com/maragudk/service/sqltest"
 )
 
@@ -37,7 +42,7 @@ func TestRunner_Start(t *testing.T) {
 			return nil
 		})
 
-		err := db.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Second)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Second)
 		require.NoError(t, err)
 
 		// This blocks until the context is cancelled by the job function
@@ -64,14 +69,14 @@ func TestRunner_Start(t *testing.T) {
 			return nil
 		})
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
 		require.NoError(t, err)
 
 		runner.Start(ctx)
 
 		metrics, err := registry.Gather()
 		require.NoError(t, err)
-		require.Len(t, metrics, 3)
+		require.Len(t, metrics, 5)
 
 		metric := metrics[0]
 		require.Equal(t, "app_job_duration_seconds_total", metric.GetName())
@@ -82,19 +87,763 @@ func TestRunner_Start(t *testing.T) {
 		require.True(t, metric.Metric[0].Counter.GetValue() > 0)
 
 		metric = metrics[1]
+		require.Equal(t, "app_job_run_duration_seconds", metric.GetName())
+		require.Equal(t, "name", metric.Metric[0].Label[0].GetName())
+		require.Equal(t, "test", metric.Metric[0].Label[0].GetValue())
+		require.Equal(t, "success", metric.Metric[0].Label[1].GetName())
+		require.Equal(t, "true", metric.Metric[0].Label[1].GetValue())
+		require.Equal(t, uint64(1), metric.Metric[0].Histogram.GetSampleCount())
+
+		metric = metrics[2]
 		require.Equal(t, "app_job_runner_receives_total", metric.GetName())
 		require.Equal(t, "success", metric.Metric[0].Label[0].GetName())
 		require.Equal(t, "true", metric.Metric[0].Label[0].GetValue())
 		require.True(t, metric.Metric[0].Counter.GetValue() > 0)
 
-		metric = metrics[2]
+		metric = metrics[3]
+		require.Equal(t, "app_jobs_running", metric.GetName())
+		require.Equal(t, "name", metric.Metric[0].Label[0].GetName())
+		require.Equal(t, "test", metric.Metric[0].Label[0].GetValue())
+		require.Equal(t, float64(0), metric.Metric[0].Gauge.GetValue())
+
+		metric = metrics[4]
 		require.Equal(t, "app_jobs_total", metric.GetName())
 		require.Equal(t, "name", metric.Metric[0].Label[0].GetName())
 		require.Equal(t, "test", metric.Metric[0].Label[0].GetValue())
-		require.Equal(t, "success", metric.Metric[0].Label[1].GetName())
-		require.Equal(t, "true", metric.Metric[0].Label[1].GetValue())
+		require.Equal(t, "queue", metric.Metric[0].Label[1].GetName())
+		require.Equal(t, "default", metric.Metric[0].Label[1].GetValue())
+		require.Equal(t, "success", metric.Metric[0].Label[2].GetName())
+		require.Equal(t, "true", metric.Metric[0].Label[2].GetValue())
 		require.Equal(t, float64(1), metric.Metric[0].Counter.GetValue())
 	})
+
+	t.Run("retries a failed job later with backoff", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+			RetryDelay:   time.Minute,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			return errors.New("oh no")
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, 1, jobs[0].Attempts)
+		require.Nil(t, jobs[0].Received)
+		require.WithinRange(t, jobs[0].Run.T, time.Now().Add(29*time.Second), time.Now().Add(time.Minute))
+	})
+
+	t.Run("moves a job to the failed jobs after max attempts", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			return errors.New("oh no")
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second, sql.WithMaxAttempts(1))
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+		require.Equal(t, "test", failedJobs[0].Name)
+		require.Equal(t, 1, failedJobs[0].Attempts)
+		require.Equal(t, "oh no", failedJobs[0].Error)
+	})
+
+	t.Run("runs a job created after start immediately instead of waiting for the poll interval", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Hour,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var ran bool
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			ran = true
+			cancel()
+			return nil
+		})
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+			assert.NoError(t, err)
+		}()
+
+		runner.Start(ctx)
+		require.True(t, ran)
+	})
+
+	t.Run("receives jobs up to the job limit at once", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			JobLimit:     3,
+			PollInterval: time.Hour,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		wg.Add(3)
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			wg.Done()
+			// Blocks until all three jobs are running at the same time
+			wg.Wait()
+			cancel()
+			return nil
+		})
+
+		for i := 0; i < 3; i++ {
+			_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+			require.NoError(t, err)
+		}
+
+		runner.Start(ctx)
+
+		var count int
+		err := db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("extends the lease of a running job", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			defer cancel()
+
+			// Wait for longer than the lease
+			time.Sleep(1500 * time.Millisecond)
+
+			job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+			require.NoError(t, err)
+			require.Nil(t, job)
+
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithLease(time.Second))
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+	})
+
+	t.Run("stops a running job if its lease was lost", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var jobErr error
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			defer cancel()
+
+			// As if the lease expired and the job was received again elsewhere
+			_, err := db.DB.Exec(`update jobs set received = ?`, model.Time{T: time.Now().Add(time.Minute)})
+			assert.NoError(t, err)
+
+			<-ctx.Done()
+			jobErr = ctx.Err()
+			return jobErr
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithLease(time.Second))
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.ErrorIs(t, jobErr, context.Canceled)
+
+		// The job is left alone for whoever received it again
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs where received is not null`)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("records job runs in the history", func(t *testing.T) {
+		log, logs := newLogger()
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			History:      true,
+			Log:          log,
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Contains(t, logs.String(), "Registered jobs: [cleanup-job-runs health test]")
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{Name: "test"})
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		require.Equal(t, model.JSON(`{"foo":"bar"}`), runs[0].Payload)
+		require.Equal(t, 1, runs[0].Attempt)
+		require.Equal(t, model.JobRunOutcomeSuccess, runs[0].Outcome)
+		require.Equal(t, "", runs[0].Error)
+	})
+
+	t.Run("records a failed run if the job panics", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			History: true,
+			Queue:   db,
+		})
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			panic("oh no")
+		})
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		n, err := runner.RunDue(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{JobID: id})
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		require.Equal(t, model.JobRunOutcomeFailure, runs[0].Outcome)
+		require.Equal(t, "panic: oh no", runs[0].Error)
+	})
+
+	t.Run("runs jobs after their dependencies have finished", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		// A long poll interval, so jobs only run immediately if the runner is notified about finished dependencies
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			JobLimit:     2,
+			PollInterval: time.Hour,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var ran []string
+		var ranLock sync.Mutex
+		record := func(name string) {
+			ranLock.Lock()
+			defer ranLock.Unlock()
+			ran = append(ran, name)
+		}
+
+		runner.Register("store", func(ctx context.Context, m model.Map) error {
+			record("store")
+			return nil
+		})
+		runner.Register("thumbnail", func(ctx context.Context, m model.Map) error {
+			record("thumbnail " + m["size"])
+			return nil
+		})
+		runner.Register("notify", func(ctx context.Context, m model.Map) error {
+			record("notify")
+			cancel()
+			return nil
+		})
+
+		storeID, err := db.CreateJob(context.Background(), "store", model.Map{}, time.Second)
+		require.NoError(t, err)
+		smallID, err := db.CreateJob(context.Background(), "thumbnail", model.Map{"size": "small"}, time.Second,
+			sql.WithDependencies(storeID))
+		require.NoError(t, err)
+		largeID, err := db.CreateJob(context.Background(), "thumbnail", model.Map{"size": "large"}, time.Second,
+			sql.WithDependencies(storeID))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "notify", model.Map{}, time.Second,
+			sql.WithDependencies(smallID, largeID))
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Len(t, ran, 4)
+		require.Equal(t, "store", ran[0])
+		require.ElementsMatch(t, []string{"thumbnail small", "thumbnail large"}, ran[1:3])
+		require.Equal(t, "notify", ran[3])
+	})
+}
+
+func TestRunner_Start_drain(t *testing.T) {
+	t.Run("lets running jobs finish before stopping", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			DrainTimeout: time.Second,
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var finished bool
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			finished = true
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.True(t, finished)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("waits for running jobs to finish without a drain timeout", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var finished bool
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			time.Sleep(50 * time.Millisecond)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			finished = true
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.True(t, finished)
+	})
+
+	t.Run("cancels and releases running jobs after the drain timeout", func(t *testing.T) {
+		log, logs := newLogger()
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			DrainTimeout: 10 * time.Millisecond,
+			Log:          log,
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Hour)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Contains(t, logs.String(), "Drain timeout passed, cancelling running jobs")
+		require.Contains(t, logs.String(), "Job interrupted by stopping, released: test")
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Nil(t, jobs[0].Received)
+		require.Equal(t, 0, jobs[0].Attempts)
+	})
+}
+
+func TestRunner_Start_unknownNames(t *testing.T) {
+	t.Run("doesn't receive jobs with names that aren't registered, and quarantines them later", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval:    time.Millisecond,
+			QuarantineAfter: 10 * time.Millisecond,
+			Queue:           db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		_, err := db.CreateJob(context.Background(), "unknown", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			runner.Start(ctx)
+			close(done)
+		}()
+
+		require.Eventually(t, func() bool {
+			failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+			return err == nil && len(failedJobs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		<-done
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "unknown", failedJobs[0].Name)
+		require.True(t, failedJobs[0].Quarantined)
+		// It was never received
+		require.Equal(t, 0, failedJobs[0].Attempts)
+	})
+}
+
+func TestRunner_RunDue(t *testing.T) {
+	t.Run("runs due jobs synchronously, and jobs for later after the clock has advanced", func(t *testing.T) {
+		q := jobstest.CreateQueue(t)
+		runner := jobstest.CreateRunner(t, q)
+
+		var ran []string
+		runner.Register("send-welcome", func(ctx context.Context, m model.Map) error {
+			ran = append(ran, "send-welcome "+m["user"])
+			_, err := q.CreateJobForLater(ctx, "send-follow-up", model.Map{"user": m["user"]}, time.Minute, 24*time.Hour)
+			return err
+		})
+		runner.Register("send-follow-up", func(ctx context.Context, m model.Map) error {
+			ran = append(ran, "send-follow-up "+m["user"])
+			return nil
+		})
+
+		_, err := q.CreateJob(context.Background(), "send-welcome", model.Map{"user": "1"}, time.Minute)
+		require.NoError(t, err)
+
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+		require.Equal(t, []string{"send-welcome 1"}, ran)
+		jobstest.RequireNotEnqueued(t, q, "send-welcome")
+		jobstest.RequireEnqueued(t, q, "send-follow-up", model.Map{"user": "1"})
+
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+
+		q.Clock.Advance(24 * time.Hour)
+
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+		require.Equal(t, []string{"send-welcome 1", "send-follow-up 1"}, ran)
+		jobstest.RequireNotEnqueued(t, q, "send-follow-up")
+	})
+
+	t.Run("runs scheduled jobs on the clock's schedule", func(t *testing.T) {
+		q := jobstest.CreateQueue(t)
+		runner := jobstest.CreateRunner(t, q)
+
+		var count int
+		runner.RegisterSchedule("report", jobs.Every(time.Hour), time.Minute, func(ctx context.Context, m model.Map) error {
+			count++
+			return nil
+		})
+
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+
+		q.Clock.Advance(time.Hour)
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+
+		q.Clock.Advance(time.Hour)
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+		require.Equal(t, 2, count)
+	})
+}
+
+func TestRunner_RunDue_primary(t *testing.T) {
+	t.Run("only receives jobs on the primary", func(t *testing.T) {
+		q := &replicatedQueue{Queue: jobstest.CreateQueue(t)}
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{Queue: q})
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			return nil
+		})
+
+		_, err := q.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+
+		q.primary = true
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+	})
+}
+
+// replicatedQueue can only be written to when it's the primary.
+type replicatedQueue struct {
+	*jobstest.Queue
+	primary bool
+}
+
+func (q *replicatedQueue) IsPrimary() (bool, string, error) {
+	return q.primary, "", nil
+}
+
+func TestRunner_Queues(t *testing.T) {
+	t.Run("runs jobs from named queues", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+			Queues: []jobs.QueueOptions{
+				{Name: "marketing", JobLimit: 2, PollInterval: time.Millisecond},
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.Register("test", func(ctx context.Context, m model.Map) error {
+			cancel()
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second, sql.WithQueue("marketing"))
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("logs queues with jobs that the runner doesn't have", func(t *testing.T) {
+		log, logs := newLogger()
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			Log:          log,
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Second, sql.WithQueue("marketing"))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		runner.Start(ctx)
+
+		require.Contains(t, logs.String(), "Jobs in queues that this runner doesn't have, which it won't receive: marketing")
+	})
+
+	t.Run("panics on duplicate queue names", func(t *testing.T) {
+		require.Panics(t, func() {
+			jobs.NewRunner(jobs.NewRunnerOptions{
+				Queue:  &queueMock{},
+				Queues: []jobs.QueueOptions{{Name: "default"}},
+			})
+		})
+	})
+}
+
+func TestRunner_RegisterSchedule(t *testing.T) {
+	t.Run("schedules the job and reschedules it after running", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runner.RegisterSchedule("test", jobs.Every(time.Hour), time.Second, func(ctx context.Context, m model.Map) error {
+			cancel()
+			return nil
+		})
+
+		// Make the scheduled job due immediately, as if an hour had passed
+		err := db.ScheduleJob(context.Background(), "test", "@every 1h0m0s", time.Second, time.Now())
+		require.NoError(t, err)
+
+		before := time.Now()
+		runner.Start(ctx)
+		after := time.Now()
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, "test", jobs[0].Name)
+		require.Equal(t, "@every 1h0m0s", jobs[0].Schedule)
+		require.Equal(t, 0, jobs[0].Attempts)
+		require.Nil(t, jobs[0].Received)
+		// The job is rescheduled to the next hour after it ran, somewhere between before and after
+		require.WithinRange(t, jobs[0].Run.T, before.UTC().Truncate(time.Hour).Add(time.Hour),
+			after.UTC().Truncate(time.Hour).Add(time.Hour))
+	})
+
+	t.Run("deletes the job instead of rescheduling it if the schedule has no next time", func(t *testing.T) {
+		q := jobstest.CreateQueue(t)
+		runner := jobstest.CreateRunner(t, q)
+
+		runner.RegisterSchedule("test", &endingSchedule{}, time.Second, func(ctx context.Context, m model.Map) error {
+			return nil
+		})
+
+		require.Equal(t, 0, jobstest.RunDue(t, runner))
+		jobstest.RequireEnqueued(t, q, "test", model.Map{})
+
+		q.Clock.Advance(time.Hour)
+		require.Equal(t, 1, jobstest.RunDue(t, runner))
+		jobstest.RequireNotEnqueued(t, q, "test")
+	})
+
+	t.Run("schedules the job in the given queue", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			Queue:  db,
+			Queues: []jobs.QueueOptions{{Name: "marketing"}},
+		})
+
+		runner.RegisterSchedule("test", jobs.Every(time.Hour), time.Second, func(ctx context.Context, m model.Map) error {
+			return nil
+		}, jobs.WithScheduleQueue("marketing"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		runner.Start(ctx)
+
+		var queue string
+		err := db.DB.Get(&queue, `select queue from jobs where name = 'test'`)
+		require.NoError(t, err)
+		require.Equal(t, "marketing", queue)
+	})
+
+	t.Run("panics if the runner doesn't have the queue", func(t *testing.T) {
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{Queue: &queueMock{}})
+
+		require.Panics(t, func() {
+			runner.RegisterSchedule("test", jobs.Every(time.Hour), time.Second, func(ctx context.Context, m model.Map) error {
+				return nil
+			}, jobs.WithScheduleQueue("marketing"))
+		})
+	})
+}
+
+func TestRunner_Lead(t *testing.T) {
+	t.Run("schedules jobs only when leading separately", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			LeadSeparately: true,
+			PollInterval:   time.Millisecond,
+			Queue:          db,
+		})
+
+		runner.RegisterSchedule("test", jobs.Every(time.Hour), time.Second, func(ctx context.Context, m model.Map) error {
+			return nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		runner.Start(ctx)
+
+		var count int
+		err := db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		runner.Lead(ctx)
+
+		err = db.DB.Get(&count, `select count(*) from jobs where name = 'test'`)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+}
+
+// endingSchedule has a next time an hour later only once, and then never again.
+type endingSchedule struct {
+	calls int
+}
+
+func (s *endingSchedule) Next(t time.Time) time.Time {
+	s.calls++
+	if s.calls > 1 {
+		return time.Time{}
+	}
+	return t.Add(time.Hour)
+}
+
+func (s *endingSchedule) String() string {
+	return "ending"
 }
 
 type queueMock struct {
@@ -104,7 +853,15 @@ func (q *queueMock) DeleteJob(ctx context.Context, id int) error {
 	panic("implement me")
 }
 
-func (q *queueMock) GetJob(ctx context.Context) (*model.Job, error) {
+func (q *queueMock) FailJob(ctx context.Context, id int, reason string) error {
+	panic("implement me")
+}
+
+func (q *queueMock) GetJob(ctx context.Context, opts sql.GetJobOptions) (*model.Job, error) {
+	panic("implement me")
+}
+
+func (q *queueMock) RetryJob(ctx context.Context, id int, after time.Duration) error {
 	panic("implement me")
 }
 
@@ -130,6 +887,55 @@ func TestRunner_Register(t *testing.T) {
 			return nil
 		})
 	})
+
+	t.Run("leaves jobs over the rate limit in the queue", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			JobLimit:     10,
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var count int
+		var countLock sync.Mutex
+		runner.Register("send-email", func(ctx context.Context, m model.Map) error {
+			countLock.Lock()
+			defer countLock.Unlock()
+			count++
+			if count == 2 {
+				// Give the runner time to receive more jobs, which it shouldn't
+				time.AfterFunc(100*time.Millisecond, cancel)
+			}
+			return nil
+		}, jobs.WithRateLimit(2, time.Hour))
+
+		for i := 0; i < 3; i++ {
+			_, err := db.CreateJob(context.Background(), "send-email", model.Map{}, time.Second)
+			require.NoError(t, err)
+		}
+
+		runner.Start(ctx)
+
+		require.Equal(t, 2, count)
+
+		var pending int
+		err := db.DB.Get(&pending, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 1, pending)
+	})
+
+	t.Run("panics on invalid rate limits", func(t *testing.T) {
+		require.Panics(t, func() {
+			jobs.WithRateLimit(0, time.Second)
+		})
+		require.Panics(t, func() {
+			jobs.WithRateLimit(1, 48*time.Hour)
+		})
+	})
 }
 
 func newLogger() (*log.Logger, *strings.Builder) {
@@ -0,0 +1,196 @@
+package jobs
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maragudk/errors"
+)
+
+// Schedule for recurring jobs. See Runner.RegisterSchedule.
+type Schedule interface {
+	// Next time to run strictly after t.
+	Next(t time.Time) time.Time
+	// String representation of the schedule, which is stored with the job.
+	String() string
+}
+
+type interval time.Duration
+
+// Every returns a Schedule that runs at a fixed interval.
+// Occurrences are aligned to the Unix epoch, so every runner agrees on when they are.
+func Every(d time.Duration) Schedule {
+	if d <= 0 {
+		panic("interval must be positive")
+	}
+	return interval(d)
+}
+
+func (i interval) Next(t time.Time) time.Time {
+	d := time.Duration(i)
+	return t.Truncate(d).Add(d)
+}
+
+func (i interval) String() string {
+	return "@every " + time.Duration(i).String()
+}
+
+// cronSchedule is a parsed cron expression, with a bit set for every allowed value of each field.
+type cronSchedule struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Cron returns a Schedule from a standard five-field cron expression
+// (minute, hour, day of month, month, day of week), evaluated in UTC.
+// Fields can be "*", numbers, ranges like "1-5", lists like "1,15", and steps like "*/15" or "0-30/10".
+// Aliases like "@daily" and "@hourly" are also supported.
+// Panics if the expression is invalid. See ParseCron.
+func Cron(expr string) Schedule {
+	s, err := ParseCron(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// ParseCron like Cron, but returns an error if the expression is invalid.
+// Expressions that never match within five years, like "0 0 30 2 *" for February 30th, are invalid.
+func ParseCron(expr string) (Schedule, error) {
+	fieldsExpr := expr
+	if alias, ok := cronAliases[expr]; ok {
+		fieldsExpr = alias
+	}
+
+	fields := strings.Fields(fieldsExpr)
+	if len(fields) != 5 {
+		return nil, errors.Newf("cron expression %q must have five fields", expr)
+	}
+
+	s := cronSchedule{expr: expr}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrap(err, "invalid minute in cron expression %q", expr)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, errors.Wrap(err, "invalid hour in cron expression %q", expr)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, errors.Wrap(err, "invalid day of month in cron expression %q", expr)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, errors.Wrap(err, "invalid month in cron expression %q", expr)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, errors.Wrap(err, "invalid day of week in cron expression %q", expr)
+	}
+	// Both 0 and 7 are Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	// Like cron, fields starting with * are unrestricted, also with a step
+	s.domRestricted = !strings.HasPrefix(fields[2], "*")
+	s.dowRestricted = !strings.HasPrefix(fields[4], "*")
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, errors.Newf("cron expression %q never matches", expr)
+	}
+
+	return s, nil
+}
+
+// parseCronField into a bit set of allowed values between min and max.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, errors.Newf("invalid step %q", stepPart)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startPart); err != nil {
+				return 0, errors.Newf("invalid value %q", startPart)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endPart); err != nil {
+					return 0, errors.Newf("invalid value %q", endPart)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, errors.Newf("%q is out of range %v-%v", part, min, max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func (c cronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	// The expression can never match, like February 30th
+	return time.Time{}
+}
+
+// matchesDay like cron does: if both day of month and day of week are restricted, either can match.
+func (c cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := c.dom&(1<<t.Day()) != 0
+	dowMatch := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domRestricted && c.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func (c cronSchedule) String() string {
+	return c.expr
+}
@@ -0,0 +1,75 @@
+package jobs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+)
+
+func TestEvery(t *testing.T) {
+	t.Run("returns the next interval aligned to the epoch", func(t *testing.T) {
+		s := jobs.Every(time.Hour)
+		require.Equal(t, "@every 1h0m0s", s.String())
+
+		now := time.Date(2022, 11, 18, 14, 30, 0, 0, time.UTC)
+		require.Equal(t, time.Date(2022, 11, 18, 15, 0, 0, 0, time.UTC), s.Next(now))
+		require.Equal(t, time.Date(2022, 11, 18, 16, 0, 0, 0, time.UTC), s.Next(s.Next(now)))
+	})
+}
+
+func TestCron(t *testing.T) {
+	now := time.Date(2022, 11, 18, 14, 30, 20, 0, time.UTC) // A Friday
+
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2022, 11, 18, 14, 31, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2022, 11, 19, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2022, 11, 19, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2022, 11, 18, 15, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2022, 11, 18, 14, 45, 0, 0, time.UTC)},
+		{"0-30/10 14 * * *", time.Date(2022, 11, 19, 14, 0, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2022, 11, 21, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2022, 11, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 6", time.Date(2022, 11, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 1", time.Date(2022, 11, 21, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			s := jobs.Cron(test.expr)
+			require.Equal(t, test.expr, s.String())
+			require.Equal(t, test.expected, s.Next(now))
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+	}
+
+	for _, test := range tests {
+		t.Run("returns error on "+test, func(t *testing.T) {
+			_, err := jobs.ParseCron(test)
+			require.Error(t, err)
+		})
+	}
+}
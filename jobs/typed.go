@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+// TypedFunc is like Func, but with the payload decoded into a value of type T.
+type TypedFunc[T any] func(ctx context.Context, payload T) error
+
+// RegisterTyped job by name, with the JSON payload decoded into a value of type T before calling the job.
+// If the payload can't be decoded, the job fails permanently without being retried.
+// Use CreateTypedJob to create jobs with a payload of the same type.
+func RegisterTyped[T any](r registry, name string, fn TypedFunc[T], opts ...RegisterOption) {
+	r.registerHandler(name, func(ctx context.Context, payload model.JSON) error {
+		var v T
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return Permanent(errors.Wrap(err, "error decoding payload of job %v", name))
+		}
+		return fn(ctx, v)
+	}, opts...)
+}
+
+// jobCreator can create jobs with any payload that can be encoded as JSON, like sql.Database.
+type jobCreator interface {
+	CreateJobForLater(ctx context.Context, name string, payload any, timeout, after time.Duration, opts ...sql.JobOption) (int, error)
+}
+
+// CreateTypedJob to run immediately, for jobs registered with RegisterTyped, returning its ID.
+func CreateTypedJob[T any](ctx context.Context, c jobCreator, name string, payload T, timeout time.Duration, opts ...sql.JobOption) (int, error) {
+	return CreateTypedJobForLater(ctx, c, name, payload, timeout, 0, opts...)
+}
+
+// CreateTypedJobForLater is like CreateTypedJob, but runs the job after the given duration.
+func CreateTypedJobForLater[T any](ctx context.Context, c jobCreator, name string, payload T, timeout, after time.Duration, opts ...sql.JobOption) (int, error) {
+	return c.CreateJobForLater(ctx, name, payload, timeout, after, opts...)
+}
+
+// permanentError is an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error returned from a job as permanent, so the job is failed right away instead of retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent returns whether the error or any error it wraps was marked with Permanent.
+func IsPermanent(err error) bool {
+	var permanentErr permanentError
+	return errors.As(err, &permanentErr)
+}
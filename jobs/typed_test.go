@@ -0,0 +1,103 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+type welcomeEmail struct {
+	UserID int
+	Name   string
+}
+
+func TestRegisterTyped(t *testing.T) {
+	t.Run("decodes the payload into the given type", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var payload welcomeEmail
+		jobs.RegisterTyped(runner, "welcome-email", func(ctx context.Context, p welcomeEmail) error {
+			payload = p
+			cancel()
+			return nil
+		})
+
+		_, err := jobs.CreateTypedJob(context.Background(), db, "welcome-email", welcomeEmail{UserID: 1, Name: "Me"}, time.Second)
+		require.NoError(t, err)
+
+		runner.Start(ctx)
+
+		require.Equal(t, welcomeEmail{UserID: 1, Name: "Me"}, payload)
+	})
+
+	t.Run("fails the job permanently if the payload can't be decoded", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		runner := jobs.NewRunner(jobs.NewRunnerOptions{
+			PollInterval: time.Millisecond,
+			Queue:        db,
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		jobs.RegisterTyped(runner, "welcome-email", func(ctx context.Context, p welcomeEmail) error {
+			return nil
+		})
+
+		_, err := db.CreateJob(context.Background(), "welcome-email", model.Map{"UserID": "not a number"}, time.Second)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			runner.Start(ctx)
+			close(done)
+		}()
+
+		var failedJobs []model.FailedJob
+		require.Eventually(t, func() bool {
+			failedJobs, err = db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+			return err == nil && len(failedJobs) == 1
+		}, time.Second, time.Millisecond)
+
+		cancel()
+		<-done
+
+		require.Equal(t, 1, failedJobs[0].Attempts)
+		require.Contains(t, failedJobs[0].Error, "error decoding payload of job welcome-email")
+	})
+}
+
+func TestPermanent(t *testing.T) {
+	t.Run("marks an error as permanent", func(t *testing.T) {
+		err := jobs.Permanent(errors.New("oh no"))
+		require.True(t, jobs.IsPermanent(err))
+		require.Equal(t, "oh no", err.Error())
+	})
+
+	t.Run("finds wrapped permanent errors", func(t *testing.T) {
+		err := fmt.Errorf("wrapped: %w", jobs.Permanent(errors.New("oh no")))
+		require.True(t, jobs.IsPermanent(err))
+	})
+
+	t.Run("returns nil for nil errors", func(t *testing.T) {
+		require.Nil(t, jobs.Permanent(nil))
+		require.False(t, jobs.IsPermanent(errors.New("oh no")))
+	})
+}
@@ -0,0 +1,159 @@
+// Package jobstest has helpers for testing jobs deterministically, with a fake clock and synchronous job runs
+// instead of a started jobs.Runner.
+package jobstest
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maragudk/service/jobs"
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+// Clock that only moves when advanced.
+type Clock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+// NewClock at a fixed time, 2022-12-01 12:00 UTC.
+func NewClock() *Clock {
+	return &Clock{t: time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// Now is the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t
+}
+
+// Advance the clock by the given duration, for example past the delay of jobs.MemoryQueue.CreateJobForLater.
+// It only affects queues and runners using the clock, so not sql.Database, which always uses the current time.
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Queue is a jobs.MemoryQueue with a Clock, so jobs only become due when the clock is advanced.
+type Queue struct {
+	*jobs.MemoryQueue
+	Clock *Clock
+}
+
+// CreateQueue for testing.
+func CreateQueue(t *testing.T) *Queue {
+	t.Helper()
+
+	c := NewClock()
+	return &Queue{
+		MemoryQueue: jobs.NewMemoryQueue(jobs.NewMemoryQueueOptions{Now: c.Now}),
+		Clock:       c,
+	}
+}
+
+// CreateRunner for testing, running jobs from the given queue with the time of its clock.
+// Register jobs on it and run them with RunDue instead of starting it.
+func CreateRunner(t *testing.T, q *Queue) *jobs.Runner {
+	t.Helper()
+
+	return jobs.NewRunner(jobs.NewRunnerOptions{
+		Now:   q.Clock.Now,
+		Queue: q,
+	})
+}
+
+// RunDue jobs synchronously with the runner, until no more jobs are due. Returns how many jobs were run.
+// Fails the test if jobs cannot be received. See jobs.Runner.RunDue.
+func RunDue(t *testing.T, r *jobs.Runner) int {
+	t.Helper()
+
+	count, err := r.RunDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// jobGetter is a queue that can list its jobs, like sql.Database and jobs.MemoryQueue.
+type jobGetter interface {
+	GetJobs(ctx context.Context, opts sql.GetJobsOptions) ([]model.Job, error)
+}
+
+// RequireEnqueued fails the test unless a job with the given name and payload is in the queue,
+// whether it's pending, running or scheduled. The payload is compared as JSON. Returns the job.
+func RequireEnqueued(t *testing.T, q jobGetter, name string, payload any) model.Job {
+	t.Helper()
+
+	expected := normalizeJSON(t, payload)
+
+	jobs := getJobs(t, q, name)
+	for _, j := range jobs {
+		var actual any
+		if err := json.Unmarshal(j.Payload, &actual); err != nil {
+			t.Fatal(err)
+		}
+		if reflect.DeepEqual(expected, actual) {
+			return j
+		}
+	}
+
+	var payloads []string
+	for _, j := range jobs {
+		payloads = append(payloads, string(j.Payload))
+	}
+	t.Fatalf("no job %v enqueued with payload %v, found payloads %v", name, string(mustMarshal(t, payload)), payloads)
+	return model.Job{}
+}
+
+// RequireNotEnqueued fails the test if a job with the given name is in the queue,
+// whether it's pending, running or scheduled.
+func RequireNotEnqueued(t *testing.T, q jobGetter, name string) {
+	t.Helper()
+
+	if jobs := getJobs(t, q, name); len(jobs) > 0 {
+		t.Fatalf("expected no job %v enqueued, found %v", name, len(jobs))
+	}
+}
+
+// getJobs with the given name in all states.
+func getJobs(t *testing.T, q jobGetter, name string) []model.Job {
+	t.Helper()
+
+	var jobs []model.Job
+	for _, state := range []model.JobState{model.JobStatePending, model.JobStateRunning, model.JobStateScheduled} {
+		js, err := q.GetJobs(context.Background(), sql.GetJobsOptions{Name: name, State: state})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, js...)
+	}
+	return jobs
+}
+
+// normalizeJSON by encoding and decoding v, so it can be compared with decoded payloads.
+func normalizeJSON(t *testing.T, v any) any {
+	t.Helper()
+
+	var normalized any
+	if err := json.Unmarshal(mustMarshal(t, v), &normalized); err != nil {
+		t.Fatal(err)
+	}
+	return normalized
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -43,14 +43,128 @@ func (t *Time) Scan(src any) error {
 }
 
 type Job struct {
-	ID       int
-	Name     string
-	Payload  Map
-	Timeout  time.Duration
-	Run      Time
-	Received *Time
-	Created  Time
-	Updated  Time
+	ID              int
+	Name            string
+	Queue           string
+	Priority        int
+	Payload         JSON
+	Timeout         time.Duration
+	Lease           time.Duration
+	Run             Time
+	Received        *Time
+	Attempts        int
+	MaxAttempts     int `db:"max_attempts"`
+	Schedule        string
+	Key             string
+	Batch           string
+	Progress        int
+	ProgressMessage string `db:"progress_message"`
+	Result          JSON
+	Created         Time
+	Updated         Time
+}
+
+// FailedJob is a Job that has been given up on, kept with the last error for inspection.
+// Quarantined jobs were never run, because no runner had a job registered with their name.
+type FailedJob struct {
+	ID          int
+	Name        string
+	Queue       string
+	Priority    int
+	Payload     JSON
+	Timeout     time.Duration
+	Lease       time.Duration
+	Attempts    int
+	MaxAttempts int `db:"max_attempts"`
+	Batch       string
+	Error       string
+	Quarantined bool
+	JobID       int `db:"job_id"` // JobID is the ID the job had before it failed
+	Created     Time
+	Failed      Time
+}
+
+// Batch of jobs created together, see sql.WithBatch.
+// Pending jobs are all jobs of the batch still in the queue, including the ones that are running.
+// Completed jobs are the ones that are neither pending nor failed, including cancelled jobs. Failed jobs that have
+// been deleted from the failed jobs since, for example with sql.Database.PurgeFailedJobs, count as completed too.
+type Batch struct {
+	ID        string
+	Total     int
+	Pending   int
+	Failed    int
+	Completed int
+	Created   Time
+}
+
+// JobState is the state of a Job.
+type JobState string
+
+const (
+	// JobStatePending jobs are due to run, but not running.
+	JobStatePending = JobState("pending")
+	// JobStateRunning jobs have been received and their timeout or lease hasn't expired.
+	JobStateRunning = JobState("running")
+	// JobStateScheduled jobs are waiting for their run time.
+	JobStateScheduled = JobState("scheduled")
+	// JobStateCompleted jobs have finished successfully.
+	JobStateCompleted = JobState("completed")
+	// JobStateFailed jobs have been given up on.
+	JobStateFailed = JobState("failed")
+)
+
+// JobStatus of a Job, with the progress and result it has reported. See sql.Database.GetJobStatus.
+type JobStatus struct {
+	ID              int
+	Name            string
+	State           JobState
+	Progress        int
+	ProgressMessage string `db:"progress_message"`
+	Result          JSON
+	Error           string
+}
+
+// JobCount is the number of jobs with a name, per state.
+type JobCount struct {
+	Name      string
+	Pending   int
+	Running   int
+	Scheduled int
+	Failed    int
+}
+
+// Lease with a name, held by an owner until it expires. See sql.Database.AcquireLease.
+// The Token increases every time the lease changes owner.
+type Lease struct {
+	Name    string
+	Owner   string
+	Expires Time
+	Token   int
+	Created Time
+	Updated Time
+}
+
+type JobRunOutcome string
+
+const (
+	JobRunOutcomeSuccess = JobRunOutcome("success")
+	JobRunOutcomeRetry   = JobRunOutcome("retry")
+	JobRunOutcomeFailure = JobRunOutcome("failure")
+)
+
+// JobRun is a record of a single run of a Job, kept in the job history.
+type JobRun struct {
+	ID      int
+	JobID   int `db:"job_id"`
+	Name    string
+	Queue   string
+	Payload JSON
+	Attempt int
+	Started Time
+	Ended   Time
+	Outcome JobRunOutcome
+	Error   string
+	Created Time
 }
 
 type Map map[string]string
@@ -77,3 +191,43 @@ func (m *Map) Scan(src any) error {
 
 	return json.Unmarshal([]byte(s), m)
 }
+
+// JSON document, stored as text.
+type JSON []byte
+
+// MarshalJSON satisfies json.Marshaler, returning the document as is.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if j == nil {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+// UnmarshalJSON satisfies json.Unmarshaler, keeping a copy of the document.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[0:0], data...)
+	return nil
+}
+
+// Value satisfies driver.Valuer interface.
+func (j JSON) Value() (driver.Value, error) {
+	if j == nil {
+		return "null", nil
+	}
+	return string(j), nil
+}
+
+// Scan satisfies sql.Scanner interface.
+func (j *JSON) Scan(src any) error {
+	switch src := src.(type) {
+	case nil:
+		return nil
+	case string:
+		*j = JSON(src)
+	case []byte:
+		*j = append((*j)[0:0], src...)
+	default:
+		return errors.Newf("error scanning json, got %+v", src)
+	}
+	return nil
+}
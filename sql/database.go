@@ -6,9 +6,11 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/maragudk/errors"
 	"github.com/maragudk/migrate"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/prometheus/client_golang/prometheus"
@@ -22,16 +24,24 @@ type Database struct {
 	maxIdleConnections    int
 	connectionMaxLifetime time.Duration
 	connectionMaxIdleTime time.Duration
+	liteFSDir             string
 	log                   *log.Logger
 	metrics               *prometheus.Registry
+	primary               primaryCache
+	subscribers           map[chan struct{}]struct{}
+	subscribersLock       sync.Mutex
 }
 
+// NewDatabaseOptions for NewDatabase.
+// LiteFSDir is the directory LiteFS mounts the database in. If set, it's used to detect whether this instance is the
+// primary, see IsPrimary.
 type NewDatabaseOptions struct {
 	URL                   string
 	MaxOpenConnections    int
 	MaxIdleConnections    int
 	ConnectionMaxLifetime time.Duration
 	ConnectionMaxIdleTime time.Duration
+	LiteFSDir             string
 	Log                   *log.Logger
 	Metrics               *prometheus.Registry
 }
@@ -50,7 +60,9 @@ func NewDatabase(opts NewDatabaseOptions) *Database {
 	// - Set WAL mode (not strictly necessary each time because it's persisted in the database, but good for first run)
 	// - Set busy timeout, so concurrent writers wait on each other instead of erroring immediately
 	// - Enable foreign key checks
-	opts.URL += "?_journal=WAL&_timeout=5000&_fk=true"
+	// - Begin transactions immediately, so transactions that read before they write wait on the busy timeout as well,
+	//   instead of failing with "database is locked" when another connection has written in the meantime
+	opts.URL += "?_journal=WAL&_timeout=5000&_fk=true&_txlock=immediate"
 
 	return &Database{
 		url:                   opts.URL,
@@ -58,8 +70,10 @@ func NewDatabase(opts NewDatabaseOptions) *Database {
 		maxIdleConnections:    opts.MaxIdleConnections,
 		connectionMaxLifetime: opts.ConnectionMaxLifetime,
 		connectionMaxIdleTime: opts.ConnectionMaxIdleTime,
+		liteFSDir:             opts.LiteFSDir,
 		log:                   opts.Log,
 		metrics:               opts.Metrics,
+		subscribers:           map[chan struct{}]struct{}{},
 	}
 }
 
@@ -87,10 +101,46 @@ func (d *Database) Connect() error {
 	d.DB.SetConnMaxIdleTime(d.connectionMaxIdleTime)
 
 	d.metrics.MustRegister(collectors.NewDBStatsCollector(d.DB.DB, "app"))
+	d.metrics.MustRegister(newJobsCollector(d))
 
 	return nil
 }
 
+// InTransaction runs callback in a transaction, and makes sure to handle rollbacks, commits etc.
+// If the callback returns an error, the transaction is rolled back and the error returned.
+// If the callback panics, the transaction is rolled back and the panic continues.
+func (d *Database) InTransaction(ctx context.Context, callback func(tx *sqlx.Tx) error) error {
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+	defer func() {
+		if rec := recover(); rec != nil {
+			_ = tx.Rollback()
+			panic(rec)
+		}
+	}()
+	if err := callback(tx); err != nil {
+		return rollback(tx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "error committing transaction")
+	}
+
+	// Jobs may have been created in the transaction, and they're visible now that it's committed
+	d.notifyJobCreated()
+
+	return nil
+}
+
+// rollback a transaction, handling both the original error and any transaction rollback errors.
+func rollback(tx *sqlx.Tx, err error) error {
+	if txErr := tx.Rollback(); txErr != nil {
+		return errors.Wrap(err, "error rolling back transaction after error (transaction error: %v), original error", txErr)
+	}
+	return err
+}
+
 //go:embed migrations
 var migrations embed.FS
 
@@ -2,10 +2,17 @@ package sql_test
 
 import (
 	"context"
+	"errors"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
 	"github.com/maragudk/service/sqltest"
 )
 
@@ -16,3 +23,98 @@ func TestDatabase_MigrateDown(t *testing.T) {
 		require.NoError(t, err)
 	})
 }
+
+func TestDatabase_InTransaction(t *testing.T) {
+	t.Run("commits if the callback returns no error", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			_, err := db.CreateJobTx(context.Background(), tx, "test", model.Map{}, time.Minute, 0)
+			return err
+		})
+		require.NoError(t, err)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("rolls back if the callback returns an error", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			_, err := db.CreateJobTx(context.Background(), tx, "test", model.Map{}, time.Minute, 0)
+			require.NoError(t, err)
+			return errors.New("oh no")
+		})
+		require.Error(t, err)
+		require.Equal(t, "oh no", err.Error())
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+	t.Run("rolls back and panics again if the callback panics", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		require.PanicsWithValue(t, "oh no", func() {
+			_ = db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+				_, err := db.CreateJobTx(context.Background(), tx, "test", model.Map{}, time.Minute, 0)
+				require.NoError(t, err)
+				panic("oh no")
+			})
+		})
+
+		// The test database has a single connection, so this would block if the transaction was left open
+		var count int
+		err := db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("waits for other writers in transactions that read before they write", func(t *testing.T) {
+		db := sql.NewDatabase(sql.NewDatabaseOptions{
+			URL:                filepath.Join(t.TempDir(), "app.db"),
+			MaxOpenConnections: 10,
+			MaxIdleConnections: 10,
+		})
+		err := db.Connect()
+		require.NoError(t, err)
+		err = db.MigrateUp(context.Background())
+		require.NoError(t, err)
+
+		for i := 0; i < 400; i++ {
+			_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 400)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+					if err != nil || job == nil {
+						errs <- err
+						continue
+					}
+					if i%2 == 0 {
+						errs <- db.RetryJob(context.Background(), job.ID, time.Hour)
+					} else {
+						errs <- db.FailJob(context.Background(), job.ID, "oh no")
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+)
+
+// createJobsChunkSize is how many jobs are inserted per statement in CreateJobs,
+// to stay well below the SQLite limit on the number of variables in a statement.
+const createJobsChunkSize = 1000
+
+// BatchJob is a job to create with CreateJobs.
+type BatchJob struct {
+	Name    string
+	Payload any
+	Timeout time.Duration
+	// After is how long to wait before running the job. Defaults to running it immediately.
+	After time.Duration
+}
+
+// CreateJobs in a single transaction, returning their IDs in the same order as the jobs.
+// The options apply to all jobs. Use WithBatch to track the progress of the jobs with GetBatch.
+// Jobs created in bulk cannot have a key, so WithKey panics here.
+func (d *Database) CreateJobs(ctx context.Context, jobs []BatchJob, opts ...JobOption) ([]int, error) {
+	o := ApplyJobOptions(opts...)
+	if o.Key != "" {
+		panic("jobs created in bulk cannot have a key")
+	}
+
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	for _, j := range jobs {
+		if j.Name == "" {
+			panic("job name cannot be empty")
+		}
+	}
+
+	ids := make([]int, 0, len(jobs))
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		now := time.Now()
+
+		for start := 0; start < len(jobs); start += createJobsChunkSize {
+			end := start + createJobsChunkSize
+			if end > len(jobs) {
+				end = len(jobs)
+			}
+			chunk := jobs[start:end]
+
+			var values []string
+			var args []any
+			for i, j := range chunk {
+				data, err := json.Marshal(j.Payload)
+				if err != nil {
+					return errors.Wrap(err, "error encoding payload of job %v", start+i)
+				}
+				values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+				args = append(args, j.Name, model.JSON(data), j.Timeout, o.Lease, model.Time{T: now.Add(j.After)},
+					o.MaxAttempts, o.Queue, o.Priority, o.Batch)
+			}
+
+			query := `
+				insert into jobs (name, payload, timeout, lease, run, max_attempts, queue, priority, batch)
+				values ` + strings.Join(values, ", ") + `
+				returning id`
+			var chunkIDs []int
+			if err := tx.SelectContext(ctx, &chunkIDs, query, args...); err != nil {
+				return err
+			}
+			// The rows are returned in no particular order, but IDs are assigned in insert order
+			sort.Ints(chunkIDs)
+
+			if err := addJobDependencies(ctx, tx, chunkIDs[0], chunkIDs[len(chunkIDs)-1], o.Dependencies); err != nil {
+				return err
+			}
+
+			ids = append(ids, chunkIDs...)
+		}
+
+		return addToBatch(ctx, tx, o.Batch, len(jobs))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// addToBatch with the given ID, creating it if it doesn't exist. Does nothing if the ID is empty.
+func addToBatch(ctx context.Context, tx *sqlx.Tx, id string, count int) error {
+	if id == "" {
+		return nil
+	}
+	query := `
+		insert into job_batches (id, total) values (?, ?)
+		on conflict (id) do update set total = total + excluded.total`
+	_, err := tx.ExecContext(ctx, query, id, count)
+	return err
+}
+
+// GetBatch with progress of its jobs. Returns nil if there is no such batch.
+// Completed jobs are counted as the ones no longer pending or failed, see model.Batch for what that includes.
+func (d *Database) GetBatch(ctx context.Context, id string) (*model.Batch, error) {
+	var b model.Batch
+	query := `
+		select
+			id,
+			total,
+			(select count(*) from jobs where batch = job_batches.id) as pending,
+			(select count(*) from jobs_failed where batch = job_batches.id) as failed,
+			created
+		from job_batches
+		where id = ?`
+	if err := d.DB.GetContext(ctx, &b, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	b.Completed = b.Total - b.Pending - b.Failed
+	return &b, nil
+}
@@ -0,0 +1,163 @@
+package sql_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestDatabase_CreateJobs(t *testing.T) {
+	t.Run("creates many jobs at once and returns their IDs in order", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		var jobs []sql.BatchJob
+		for i := 0; i < 2500; i++ {
+			jobs = append(jobs, sql.BatchJob{
+				Name:    "send-newsletter",
+				Payload: model.Map{"recipient": strconv.Itoa(i)},
+				Timeout: time.Minute,
+			})
+		}
+
+		ids, err := db.CreateJobs(context.Background(), jobs, sql.WithQueue("newsletter"), sql.WithMaxAttempts(5))
+		require.NoError(t, err)
+		require.Len(t, ids, 2500)
+
+		var created []model.Job
+		err = db.DB.Select(&created, `select * from jobs order by id`)
+		require.NoError(t, err)
+		require.Len(t, created, 2500)
+
+		for i, j := range created {
+			require.Equal(t, ids[i], j.ID)
+			require.Equal(t, "send-newsletter", j.Name)
+			require.Equal(t, model.JSON(`{"recipient":"`+strconv.Itoa(i)+`"}`), j.Payload)
+			require.Equal(t, "newsletter", j.Queue)
+			require.Equal(t, 5, j.MaxAttempts)
+		}
+	})
+
+	t.Run("creates jobs for later", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJobs(context.Background(), []sql.BatchJob{
+			{Name: "now", Payload: model.Map{}, Timeout: time.Minute},
+			{Name: "later", Payload: model.Map{}, Timeout: time.Minute, After: time.Hour},
+		})
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "now", job.Name)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+
+	t.Run("adds dependencies to all jobs", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		storeID, err := db.CreateJob(context.Background(), "store", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		_, err = db.CreateJobs(context.Background(), []sql.BatchJob{
+			{Name: "thumbnail", Payload: model.Map{"size": "small"}, Timeout: time.Minute},
+			{Name: "thumbnail", Payload: model.Map{"size": "large"}, Timeout: time.Minute},
+		}, sql.WithDependencies(storeID))
+		require.NoError(t, err)
+
+		err = db.FailJob(context.Background(), storeID, "oh no")
+		require.NoError(t, err)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 3)
+	})
+
+	t.Run("does nothing without jobs", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		ids, err := db.CreateJobs(context.Background(), nil)
+		require.NoError(t, err)
+		require.Len(t, ids, 0)
+	})
+
+	t.Run("panics with a key", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		require.Panics(t, func() {
+			_, _ = db.CreateJobs(context.Background(), []sql.BatchJob{{Name: "test", Timeout: time.Minute}}, sql.WithKey("foo"))
+		})
+	})
+
+	t.Run("panics with an empty name and leaves the database usable", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		require.Panics(t, func() {
+			_, _ = db.CreateJobs(context.Background(), []sql.BatchJob{
+				{Name: "test", Payload: model.Map{}, Timeout: time.Minute},
+				{Name: "", Payload: model.Map{}, Timeout: time.Minute},
+			})
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+	})
+}
+
+func TestDatabase_GetBatch(t *testing.T) {
+	t.Run("returns the progress of a batch", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		ids, err := db.CreateJobs(context.Background(), []sql.BatchJob{
+			{Name: "test", Payload: model.Map{}, Timeout: time.Minute},
+			{Name: "test", Payload: model.Map{}, Timeout: time.Minute},
+			{Name: "test", Payload: model.Map{}, Timeout: time.Minute},
+		}, sql.WithBatch("newsletter-1"))
+		require.NoError(t, err)
+
+		// Jobs can be added to the batch later
+		_, err = db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithBatch("newsletter-1"))
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithBatch("newsletter-2"))
+		require.NoError(t, err)
+
+		err = db.DeleteJob(context.Background(), ids[0])
+		require.NoError(t, err)
+		err = db.FailJob(context.Background(), ids[1], "oh no")
+		require.NoError(t, err)
+
+		b, err := db.GetBatch(context.Background(), "newsletter-1")
+		require.NoError(t, err)
+		require.NotNil(t, b)
+		require.Equal(t, "newsletter-1", b.ID)
+		require.Equal(t, 4, b.Total)
+		require.Equal(t, 2, b.Pending)
+		require.Equal(t, 1, b.Failed)
+		require.Equal(t, 1, b.Completed)
+		require.WithinDuration(t, time.Now(), b.Created.T, time.Second)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+		require.Equal(t, "newsletter-1", failedJobs[0].Batch)
+	})
+
+	t.Run("returns nil if no such batch", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		b, err := db.GetBatch(context.Background(), "newsletter-1")
+		require.NoError(t, err)
+		require.Nil(t, b)
+	})
+}
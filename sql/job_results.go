@@ -0,0 +1,81 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+)
+
+// UpdateJobProgress of a received job, in percent, with a message about what it's doing.
+func (d *Database) UpdateJobProgress(ctx context.Context, id, progress int, message string) error {
+	query := `update jobs set progress = ?, progress_message = ? where id = ? and received is not null`
+	_, err := d.DB.ExecContext(ctx, query, progress, message, id)
+	return err
+}
+
+// SetJobResult of a received job, which is encoded as JSON.
+func (d *Database) SetJobResult(ctx context.Context, id int, result any) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "error encoding job result")
+	}
+	query := `update jobs set result = ? where id = ? and received is not null`
+	_, err = d.DB.ExecContext(ctx, query, model.JSON(data), id)
+	return err
+}
+
+// GetJobStatus by job ID, with the progress and result it has reported. Returns nil if there is no such job.
+// Jobs are gone once they're finished, unless they reported progress or a result, in which case their status is
+// kept as completed or failed for seven days.
+func (d *Database) GetJobStatus(ctx context.Context, id int) (*model.JobStatus, error) {
+	var s model.JobStatus
+	query := `
+		select
+			id,
+			name,
+			case
+				when ` + jobStateConditions[model.JobStateRunning] + ` then 'running'
+				when ` + jobStateConditions[model.JobStateScheduled] + ` then 'scheduled'
+				else 'pending'
+			end as state,
+			progress,
+			progress_message,
+			result,
+			'' as error
+		from jobs
+		where id = ?
+		union all
+		select
+			job_id as id,
+			name,
+			case when error = '' then 'completed' else 'failed' end as state,
+			progress,
+			progress_message,
+			result,
+			error
+		from job_results
+		where job_id = ?`
+	if err := d.DB.GetContext(ctx, &s, query, id, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &s, nil
+}
+
+// saveJobResult of a job that is about to be deleted, if it reported progress or a result.
+// The job failed if the reason is not empty.
+func saveJobResult(ctx context.Context, db sqlx.ExecerContext, id int, reason string) error {
+	query := `
+		insert or replace into job_results (job_id, name, progress, progress_message, result, error)
+		select id, name, progress, progress_message, result, ? from jobs
+		where id = ? and (progress > 0 or progress_message != '' or result is not null)`
+	_, err := db.ExecContext(ctx, query, reason, id)
+	return err
+}
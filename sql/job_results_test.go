@@ -0,0 +1,117 @@
+package sql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestDatabase_GetJobStatus(t *testing.T) {
+	t.Run("returns progress and result of a running job, and keeps them after it's completed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "export", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		s, err := db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+		require.Equal(t, model.JobStatePending, s.State)
+
+		_, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+
+		err = db.UpdateJobProgress(context.Background(), id, 50, "Exporting users")
+		require.NoError(t, err)
+		err = db.SetJobResult(context.Background(), id, model.Map{"url": "https://example.com/export.csv"})
+		require.NoError(t, err)
+
+		s, err = db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.Equal(t, model.JobStateRunning, s.State)
+		require.Equal(t, 50, s.Progress)
+		require.Equal(t, "Exporting users", s.ProgressMessage)
+		require.Equal(t, model.JSON(`{"url":"https://example.com/export.csv"}`), s.Result)
+
+		err = db.DeleteJob(context.Background(), id)
+		require.NoError(t, err)
+
+		s, err = db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+		require.Equal(t, id, s.ID)
+		require.Equal(t, "export", s.Name)
+		require.Equal(t, model.JobStateCompleted, s.State)
+		require.Equal(t, 50, s.Progress)
+		require.Equal(t, model.JSON(`{"url":"https://example.com/export.csv"}`), s.Result)
+	})
+
+	t.Run("keeps the error of a failed job that reported progress", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "export", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		_, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+
+		err = db.UpdateJobProgress(context.Background(), id, 10, "")
+		require.NoError(t, err)
+
+		err = db.FailJob(context.Background(), id, "oh no")
+		require.NoError(t, err)
+
+		s, err := db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+		require.Equal(t, model.JobStateFailed, s.State)
+		require.Equal(t, 10, s.Progress)
+		require.Equal(t, "oh no", s.Error)
+		require.Nil(t, s.Result)
+	})
+
+	t.Run("returns nil for a finished job that didn't report anything", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "health", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		err = db.DeleteJob(context.Background(), id)
+		require.NoError(t, err)
+
+		s, err := db.GetJobStatus(context.Background(), id)
+		require.NoError(t, err)
+		require.Nil(t, s)
+	})
+
+	t.Run("resets progress and result when a job is received again", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "export", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		_, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+
+		err = db.UpdateJobProgress(context.Background(), id, 90, "Almost done")
+		require.NoError(t, err)
+		err = db.SetJobResult(context.Background(), id, "partial")
+		require.NoError(t, err)
+
+		err = db.RetryJob(context.Background(), id, 0)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 0, job.Progress)
+		require.Equal(t, "", job.ProgressMessage)
+		require.Nil(t, job.Result)
+	})
+}
@@ -0,0 +1,54 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/maragudk/service/model"
+)
+
+// CreateJobRun in the job history.
+func (d *Database) CreateJobRun(ctx context.Context, r model.JobRun) error {
+	query := `
+		insert into job_runs (job_id, name, queue, payload, attempt, started, ended, outcome, error)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := d.DB.ExecContext(ctx, query, r.JobID, r.Name, r.Queue, r.Payload, r.Attempt, r.Started, r.Ended, r.Outcome,
+		r.Error)
+	return err
+}
+
+// GetJobRunsOptions for GetJobRuns.
+// If JobID is not 0, only runs of the job with that ID are returned.
+// If Name is not empty, only runs of jobs with that name are returned.
+// Limit defaults to 100.
+type GetJobRunsOptions struct {
+	JobID int
+	Limit int
+	Name  string
+}
+
+// GetJobRuns, most recently started first.
+func (d *Database) GetJobRuns(ctx context.Context, opts GetJobRunsOptions) ([]model.JobRun, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	var runs []model.JobRun
+	query := `
+		select * from job_runs
+		where (? = 0 or job_id = ?) and (? = '' or name = ?)
+		order by started desc, id desc
+		limit ?`
+	err := d.DB.SelectContext(ctx, &runs, query, opts.JobID, opts.JobID, opts.Name, opts.Name, opts.Limit)
+	return runs, err
+}
+
+// DeleteJobRunsBefore the given time, returning how many were deleted.
+func (d *Database) DeleteJobRunsBefore(ctx context.Context, t time.Time) (int, error) {
+	res, err := d.DB.ExecContext(ctx, `delete from job_runs where started < ?`, model.Time{T: t})
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
@@ -0,0 +1,118 @@
+package sql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestDatabase_CreateJobRun(t *testing.T) {
+	t.Run("creates a job run and gets it back", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		started := time.Now().Add(-time.Second)
+		err := db.CreateJobRun(context.Background(), model.JobRun{
+			JobID:   1,
+			Name:    "test",
+			Queue:   "default",
+			Payload: model.JSON(`{"foo":"bar"}`),
+			Attempt: 2,
+			Started: model.Time{T: started},
+			Ended:   model.Time{T: started.Add(time.Second)},
+			Outcome: model.JobRunOutcomeFailure,
+			Error:   "oh no",
+		})
+		require.NoError(t, err)
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{Name: "test"})
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+		require.Equal(t, 1, runs[0].JobID)
+		require.Equal(t, "test", runs[0].Name)
+		require.Equal(t, "default", runs[0].Queue)
+		require.Equal(t, model.JSON(`{"foo":"bar"}`), runs[0].Payload)
+		require.Equal(t, 2, runs[0].Attempt)
+		require.WithinDuration(t, started, runs[0].Started.T, time.Millisecond)
+		require.WithinDuration(t, started.Add(time.Second), runs[0].Ended.T, time.Millisecond)
+		require.Equal(t, model.JobRunOutcomeFailure, runs[0].Outcome)
+		require.Equal(t, "oh no", runs[0].Error)
+	})
+}
+
+func TestDatabase_GetJobRuns(t *testing.T) {
+	t.Run("gets the most recent runs first, up to the limit", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for i := 0; i < 3; i++ {
+			createJobRun(t, db, "test", time.Now().Add(time.Duration(i)*time.Minute))
+		}
+		createJobRun(t, db, "other", time.Now())
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{Limit: 2, Name: "test"})
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		require.True(t, runs[0].Started.T.After(runs[1].Started.T))
+	})
+
+	t.Run("gets the runs of a single job", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for _, jobID := range []int{1, 2, 1} {
+			err := db.CreateJobRun(context.Background(), model.JobRun{
+				JobID:   jobID,
+				Name:    "test",
+				Queue:   "default",
+				Payload: model.JSON(`{}`),
+				Attempt: 1,
+				Started: model.Time{T: time.Now()},
+				Ended:   model.Time{T: time.Now()},
+				Outcome: model.JobRunOutcomeSuccess,
+			})
+			require.NoError(t, err)
+		}
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{JobID: 1})
+		require.NoError(t, err)
+		require.Len(t, runs, 2)
+		require.Equal(t, 1, runs[0].JobID)
+		require.Equal(t, 1, runs[1].JobID)
+	})
+}
+
+func TestDatabase_DeleteJobRunsBefore(t *testing.T) {
+	t.Run("deletes job runs started before the given time", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		createJobRun(t, db, "test", time.Now().Add(-time.Hour))
+		createJobRun(t, db, "test", time.Now())
+
+		count, err := db.DeleteJobRunsBefore(context.Background(), time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		runs, err := db.GetJobRuns(context.Background(), sql.GetJobRunsOptions{Name: "test"})
+		require.NoError(t, err)
+		require.Len(t, runs, 1)
+	})
+}
+
+func createJobRun(t *testing.T, db *sql.Database, name string, started time.Time) {
+	t.Helper()
+
+	err := db.CreateJobRun(context.Background(), model.JobRun{
+		Name:    name,
+		Queue:   "default",
+		Payload: model.JSON(`{}`),
+		Attempt: 1,
+		Started: model.Time{T: started},
+		Ended:   model.Time{T: started},
+		Outcome: model.JobRunOutcomeSuccess,
+	})
+	require.NoError(t, err)
+}
@@ -3,45 +3,307 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/maragudk/errors"
 
 	"github.com/maragudk/service/model"
 )
 
-// CreateJob to run immediately.
-func (d *Database) CreateJob(ctx context.Context, name string, payload model.Map, timeout time.Duration) error {
-	return d.CreateJobForLater(ctx, name, payload, timeout, 0)
+// defaultMaxAttempts for a job, if not given with WithMaxAttempts.
+const defaultMaxAttempts = 3
+
+// DefaultQueue that jobs are put in, if not given with WithQueue.
+const DefaultQueue = "default"
+
+// JobOption changes how a job is created. See CreateJob and CreateJobForLater.
+type JobOption func(*JobOptions)
+
+// JobOptions for a job, resolved from JobOption values with ApplyJobOptions.
+// Only needed when implementing another queue than Database.
+type JobOptions struct {
+	Batch        string
+	Dependencies []int
+	Key          string
+	Lease        time.Duration
+	MaxAttempts  int
+	Priority     int
+	Queue        string
+}
+
+// ApplyJobOptions to the defaults, returning the resolved JobOptions.
+func ApplyJobOptions(opts ...JobOption) JobOptions {
+	o := JobOptions{MaxAttempts: defaultMaxAttempts, Queue: DefaultQueue}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMaxAttempts sets how many times a job is received before it is given up. Defaults to 3.
+func WithMaxAttempts(n int) JobOption {
+	if n < 1 {
+		panic("max attempts must be at least 1")
+	}
+	return func(o *JobOptions) {
+		o.MaxAttempts = n
+	}
 }
 
-func (d *Database) CreateJobForLater(ctx context.Context, name string, payload model.Map, timeout, after time.Duration) error {
+// WithQueue puts the job in the named queue instead of the default queue.
+func WithQueue(name string) JobOption {
+	if name == "" {
+		panic("queue name cannot be empty")
+	}
+	return func(o *JobOptions) {
+		o.Queue = name
+	}
+}
+
+// WithPriority for the job. Jobs with a higher priority are received before jobs with a lower one,
+// otherwise jobs are received in the order they were created. Defaults to 0.
+func WithPriority(p int) JobOption {
+	return func(o *JobOptions) {
+		o.Priority = p
+	}
+}
+
+// WithKey makes the job unique by its name and the given key, as long as it hasn't been received.
+// Creating a job with the same name and key as a pending job does nothing and returns the ID of the pending job.
+// A job with the same name and key as a received job is created, since the received job may have already read
+// whatever changed. If the received job is retried or released while the new job is pending, it's deleted instead,
+// and jobs depending on it depend on the new job.
+func WithKey(key string) JobOption {
+	if key == "" {
+		panic("key cannot be empty")
+	}
+	return func(o *JobOptions) {
+		o.Key = key
+	}
+}
+
+// WithLease makes the job invisible to GetJob for the given duration after being received, instead of for its whole
+// timeout. The lease must be extended with ExtendJobLease while the job is running, so if whatever received it stops,
+// the job can be received again quickly.
+func WithLease(d time.Duration) JobOption {
+	if d < time.Second {
+		panic("lease must be at least one second")
+	}
+	return func(o *JobOptions) {
+		o.Lease = d
+	}
+}
+
+// WithDependencies makes the job wait until all jobs with the given IDs have finished successfully.
+// If one of them fails or is cancelled, so is the job. Creating the job returns an error if one of them has already
+// failed. Any other dependency that doesn't exist is treated as finished, which includes cancelled jobs and failed
+// jobs that have since been requeued or deleted, since they can't be told apart from finished ones.
+// Don't depend on jobs with a schedule, since they never finish.
+func WithDependencies(ids ...int) JobOption {
+	return func(o *JobOptions) {
+		o.Dependencies = append(o.Dependencies, ids...)
+	}
+}
+
+// WithBatch adds the job to the batch with the given ID, so progress can be tracked with GetBatch.
+// See also CreateJobs.
+func WithBatch(id string) JobOption {
+	if id == "" {
+		panic("batch ID cannot be empty")
+	}
+	return func(o *JobOptions) {
+		o.Batch = id
+	}
+}
+
+// CreateJob to run immediately, returning its ID.
+// The payload is stored as JSON.
+func (d *Database) CreateJob(ctx context.Context, name string, payload any, timeout time.Duration, opts ...JobOption) (int, error) {
+	return d.CreateJobForLater(ctx, name, payload, timeout, 0, opts...)
+}
+
+// CreateJobForLater to run after the given duration, returning its ID.
+func (d *Database) CreateJobForLater(ctx context.Context, name string, payload any, timeout, after time.Duration, opts ...JobOption) (int, error) {
 	if name == "" {
 		panic("job name cannot be empty")
 	}
-	query := `insert into jobs (name, payload, timeout, run) values (?, ?, ?, ?)`
-	_, err := d.DB.ExecContext(ctx, query, name, payload, timeout, model.Time{T: time.Now().Add(after)})
-	return err
+
+	var id int
+	err := d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		id, err = createJob(ctx, tx, name, payload, timeout, after, opts...)
+		return err
+	})
+	return id, err
+}
+
+// CreateJobTx is like CreateJobForLater, but in the given transaction, so the job is only created if the
+// transaction is committed. Use it with InTransaction to create a job together with other changes to the database,
+// which also notifies subscribers after the commit. An empty name is an error, so the transaction can be rolled back.
+func (d *Database) CreateJobTx(ctx context.Context, tx *sqlx.Tx, name string, payload any, timeout, after time.Duration, opts ...JobOption) (int, error) {
+	return createJob(ctx, tx, name, payload, timeout, after, opts...)
+}
+
+func createJob(ctx context.Context, tx *sqlx.Tx, name string, payload any, timeout, after time.Duration, opts ...JobOption) (int, error) {
+	if name == "" {
+		return 0, errors.New("job name cannot be empty")
+	}
+
+	o := ApplyJobOptions(opts...)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding job payload")
+	}
+
+	var id int
+	for {
+		query := `
+			insert into jobs (name, payload, timeout, lease, run, max_attempts, queue, priority, key, batch)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			on conflict (name, key) where key != '' and received is null do nothing
+			returning id`
+		err = tx.GetContext(ctx, &id, query, name, model.JSON(data), timeout, o.Lease, model.Time{T: time.Now().Add(after)},
+			o.MaxAttempts, o.Queue, o.Priority, o.Key, o.Batch)
+		if !errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+
+		// The job already exists by name and key, so keep its dependencies as they are
+		err = tx.GetContext(ctx, &id, `select id from jobs where name = ? and key = ? and received is null`, name, o.Key)
+		if errors.Is(err, sql.ErrNoRows) {
+			// The existing job was received or deleted in the meantime, so try creating it again
+			continue
+		}
+		return id, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := addJobDependencies(ctx, tx, id, id, o.Dependencies); err != nil {
+		return 0, err
+	}
+
+	if err := addToBatch(ctx, tx, o.Batch, 1); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// addJobDependencies to all jobs with IDs between fromID and toID, both inclusive.
+// Returns an error if one of the dependencies has failed.
+func addJobDependencies(ctx context.Context, tx *sqlx.Tx, fromID, toID int, dependencies []int) error {
+	for _, dependencyID := range dependencies {
+		var failed bool
+		if err := tx.GetContext(ctx, &failed, `select exists (select 1 from jobs_failed where job_id = ?)`, dependencyID); err != nil {
+			return errors.Wrap(err, "error checking job dependency")
+		}
+		if failed {
+			return errors.Newf("dependency %v has failed", dependencyID)
+		}
+
+		query := `
+			insert into job_dependencies (job_id, depends_on_id)
+			select id, ? from jobs where id between ? and ? and exists (select 1 from jobs where id = ?)
+			on conflict do nothing`
+		if _, err := tx.ExecContext(ctx, query, dependencyID, fromID, toID, dependencyID); err != nil {
+			return errors.Wrap(err, "error adding job dependency")
+		}
+	}
+	return nil
+}
+
+// GetJobOptions for GetJob.
+// If Queue is empty, jobs are received from the default queue.
+// Jobs with a name in RateLimits are only received if fewer than the limit have been received in the interval,
+// counting jobs received by all processes sharing the database. Only receiving jobs with a rate limit is recorded,
+// so all processes receiving jobs with a name must pass the same rate limit for it.
+// If Names is not empty, only jobs with one of those names are received.
+type GetJobOptions struct {
+	Names      []string
+	Queue      string
+	RateLimits []RateLimit
+}
+
+// MaxRateLimitInterval is the longest interval a RateLimit can have.
+const MaxRateLimitInterval = 24 * time.Hour
+
+// RateLimit for jobs with a name, so at most Limit jobs are received per interval Per.
+type RateLimit struct {
+	Name  string
+	Limit int
+	Per   time.Duration
 }
 
 // GetJob which is eligible to run. Returns nil if no job available.
-func (d *Database) GetJob(ctx context.Context) (*model.Job, error) {
-	var job model.Job
+// Jobs with paused names are not eligible, see PauseJobs.
+// Jobs are also not eligible until all their dependencies have finished, see WithDependencies.
+// Receiving a job counts as an attempt.
+// A received job is not eligible again until its lease has expired, or its timeout if it has no lease.
+func (d *Database) GetJob(ctx context.Context, opts GetJobOptions) (*model.Job, error) {
+	if opts.Queue == "" {
+		opts.Queue = DefaultQueue
+	}
+
+	rateLimits := []RateLimit{}
+	for _, l := range opts.RateLimits {
+		if l.Per > MaxRateLimitInterval {
+			panic("rate limit interval must be at most one day")
+		}
+		rateLimits = append(rateLimits, l)
+	}
+	rateLimitsJSON, err := json.Marshal(rateLimits)
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding rate limits")
+	}
+
+	namesJSON, err := json.Marshal(append([]string{}, opts.Names...))
+	if err != nil {
+		return nil, errors.Wrap(err, "error encoding job names")
+	}
+
 	query := `
 		update jobs
-		set received = strftime('%Y-%m-%dT%H:%M:%fZ')
+		set
+			received = strftime('%Y-%m-%dT%H:%M:%fZ'),
+			attempts = attempts + 1,
+			progress = 0,
+			progress_message = '',
+			result = null
 		where id = (
 			select id from jobs
 			where
-				run <= strftime('%Y-%m-%dT%H:%M:%fZ') and (
-					received is null or
-					strftime('%Y-%m-%dT%H:%M:%fZ', received, (timeout/1000000000)||' second') <= strftime('%Y-%m-%dT%H:%M:%fZ')
-				)
-			order by created
+				queue = ? and
+				(json_array_length(?) = 0 or name in (select value from json_each(?))) and
+				name not in (
+					select value->>'Name' from json_each(?)
+					where (
+						select count(*) from job_claims
+						where
+							job_claims.name = value->>'Name' and
+							claimed > strftime('%Y-%m-%dT%H:%M:%fZ', 'now', (-(value->>'Per')/1000000000.0)||' second')
+					) >= value->>'Limit'
+				) and
+				` + jobEligible + `
+			order by priority desc, created, id
 			limit 1
 		)
 		returning *`
-	if err := d.DB.GetContext(ctx, &job, query); err != nil {
+	args := []any{opts.Queue, string(namesJSON), string(namesJSON), string(rateLimitsJSON)}
+
+	var job model.Job
+	if len(rateLimits) == 0 {
+		err = d.DB.GetContext(ctx, &job, query, args...)
+	} else {
+		err = d.getJobAndClaim(ctx, &job, query, args, rateLimits)
+	}
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
@@ -50,7 +312,556 @@ func (d *Database) GetJob(ctx context.Context) (*model.Job, error) {
 	return &job, nil
 }
 
+// getJobAndClaim with the given query, recording the claim in the same transaction, so rate limits can't be exceeded.
+// The transaction is run on a single connection instead of with InTransaction, because database/sql discards the
+// connection of a transaction if its context is cancelled, which happens every time a runner stops.
+func (d *Database) getJobAndClaim(ctx context.Context, job *model.Job, query string, args []any, rateLimits []RateLimit) error {
+	conn, err := d.DB.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.ExecContext(ctx, `begin immediate`); err != nil {
+		return errors.Wrap(err, "error beginning transaction")
+	}
+
+	err = conn.GetContext(ctx, job, query, args...)
+	if err == nil {
+		err = claimJob(ctx, conn, *job, rateLimits)
+	}
+	if err != nil {
+		// The context may be cancelled, but the transaction must still be rolled back before the connection is reused
+		if _, txErr := conn.ExecContext(context.Background(), `rollback`); txErr != nil {
+			return errors.Wrap(err, "error rolling back transaction after error (transaction error: %v), original error", txErr)
+		}
+		return err
+	}
+
+	if _, err := conn.ExecContext(context.Background(), `commit`); err != nil {
+		return errors.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
+
+// claimJob by recording that it was received, if it has a rate limit, forgetting claims for its name older than
+// MaxRateLimitInterval. See ReleaseJob for removing the claim again.
+func claimJob(ctx context.Context, tx sqlx.ExecerContext, job model.Job, rateLimits []RateLimit) error {
+	for _, l := range rateLimits {
+		if l.Name != job.Name {
+			continue
+		}
+		query := `insert into job_claims (name, job_id) values (?, ?)`
+		if _, err := tx.ExecContext(ctx, query, job.Name, job.ID); err != nil {
+			return errors.Wrap(err, "error recording job claim")
+		}
+		query = `delete from job_claims where name = ? and claimed < strftime('%Y-%m-%dT%H:%M:%fZ', 'now', '-1 day')`
+		if _, err := tx.ExecContext(ctx, query, job.Name); err != nil {
+			return errors.Wrap(err, "error deleting old job claims")
+		}
+		return nil
+	}
+	return nil
+}
+
+// ExtendJobLease of a job received at the given time, so it stays invisible to GetJob for another lease duration.
+// It returns the new received time, to pass the next time the lease is extended.
+// If the job isn't received at the given time anymore, for example because the lease expired and it was received
+// again elsewhere, nothing changes and it returns nil.
+func (d *Database) ExtendJobLease(ctx context.Context, id int, received time.Time) (*model.Time, error) {
+	query := `
+		update jobs set received = strftime('%Y-%m-%dT%H:%M:%fZ')
+		where id = ? and received = ?
+		returning received`
+	var t model.Time
+	if err := d.DB.GetContext(ctx, &t, query, id, model.Time{T: received}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RetryJob after the given duration, making it available to GetJob again.
+// If a job with the same name and key has been created since it was received, it's deleted instead, see WithKey.
+func (d *Database) RetryJob(ctx context.Context, id int, after time.Duration) error {
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `update jobs set received = null, run = ? where id = ?`
+		return makeJobPending(ctx, tx, id, query, model.Time{T: time.Now().Add(after)}, id)
+	})
+}
+
+// ReleaseJob that was received but not finished, making it available to GetJob again immediately.
+// The attempt doesn't count, since the job was interrupted, for example because the process stopped,
+// and neither does it count against its rate limit.
+// If a job with the same name and key has been created since it was received, it's deleted instead, see WithKey.
+func (d *Database) ReleaseJob(ctx context.Context, id int) error {
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			delete from job_claims where rowid = (
+				select max(rowid) from job_claims
+				where job_id = ? and exists (select 1 from jobs where id = ? and received is not null)
+			)`
+		if _, err := tx.ExecContext(ctx, query, id, id); err != nil {
+			return errors.Wrap(err, "error deleting job claim")
+		}
+
+		query = `update jobs set received = null, attempts = max(attempts - 1, 0) where id = ? and received is not null`
+		return makeJobPending(ctx, tx, id, query, id)
+	})
+}
+
+// makeJobPending with the given query, unless a pending job with the same name and key exists, since only one can be
+// pending at a time. That job does the same work, so the job is deleted instead, and its dependents depend on that job.
+func makeJobPending(ctx context.Context, tx *sqlx.Tx, id int, query string, args ...any) error {
+	var pendingID int
+	pendingQuery := `
+		select pending.id from jobs pending join jobs j on pending.name = j.name and pending.key = j.key
+		where j.id = ? and j.key != '' and pending.received is null and pending.id != j.id`
+	err := tx.GetContext(ctx, &pendingID, pendingQuery, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx, query, args...)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	dependenciesQuery := `
+		insert into job_dependencies (job_id, depends_on_id)
+		select job_id, ? from job_dependencies where depends_on_id = ? and job_id != ?
+		on conflict do nothing`
+	if _, err := tx.ExecContext(ctx, dependenciesQuery, pendingID, id, pendingID); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from jobs where id = ?`, id)
+	return err
+}
+
+// DeleteJob after it has finished. Subscribers are notified, since jobs depending on it may now be eligible to run.
+// If the job reported progress or a result, it's kept for GetJobStatus, in the same transaction as the delete.
 func (d *Database) DeleteJob(ctx context.Context, id int) error {
-	_, err := d.DB.ExecContext(ctx, `delete from jobs where id = ?`, id)
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		if err := saveJobResult(ctx, tx, id, ""); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `delete from jobs where id = ?`, id)
+		return err
+	})
+}
+
+// CancelJob by deleting it, whether it's pending or running, together with all jobs depending on it.
+// Whatever is running it is not notified, see jobs.Runner.CancelJob for that.
+func (d *Database) CancelJob(ctx context.Context, id int) error {
+	query := `
+		with recursive cancelled(id) as (
+			select ?
+			union
+			select job_dependencies.job_id from job_dependencies join cancelled on job_dependencies.depends_on_id = cancelled.id
+		)
+		delete from jobs where id in (select id from cancelled)`
+	_, err := d.DB.ExecContext(ctx, query, id)
 	return err
 }
+
+// IsJobCancelled reports whether the received job with the given ID has been cancelled, see CancelJob.
+// Cancelled jobs are deleted, so it's whether the job is gone from the queue, and it must only be called while the
+// job is running, before it's deleted, retried or failed.
+func (d *Database) IsJobCancelled(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := d.DB.GetContext(ctx, &exists, `select exists (select 1 from jobs where id = ?)`, id)
+	return !exists, err
+}
+
+// CancelJobsByName by deleting them, whether they're pending or running, together with all jobs depending on them.
+// Returns how many were cancelled, including dependent jobs.
+func (d *Database) CancelJobsByName(ctx context.Context, name string) (int, error) {
+	query := `
+		with recursive cancelled(id) as (
+			select id from jobs where name = ?
+			union
+			select job_dependencies.job_id from job_dependencies join cancelled on job_dependencies.depends_on_id = cancelled.id
+		)
+		delete from jobs where id in (select id from cancelled)`
+	res, err := d.DB.ExecContext(ctx, query, name)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// PauseJobs with the given name, so they're not received by GetJob until resumed with ResumeJobs.
+// Running jobs are not affected.
+func (d *Database) PauseJobs(ctx context.Context, name string) error {
+	_, err := d.DB.ExecContext(ctx, `insert into jobs_paused (name) values (?) on conflict do nothing`, name)
+	return err
+}
+
+// ResumeJobs with the given name, after they've been paused with PauseJobs.
+func (d *Database) ResumeJobs(ctx context.Context, name string) error {
+	_, err := d.DB.ExecContext(ctx, `delete from jobs_paused where name = ?`, name)
+	if err != nil {
+		return err
+	}
+	d.notifyJobCreated()
+	return nil
+}
+
+// GetPausedJobNames, sorted by name.
+func (d *Database) GetPausedJobNames(ctx context.Context) ([]string, error) {
+	var names []string
+	err := d.DB.SelectContext(ctx, &names, `select name from jobs_paused order by name`)
+	return names, err
+}
+
+// FailJob by moving it to the failed jobs, together with the reason it failed.
+// All jobs depending on it are failed as well.
+func (d *Database) FailJob(ctx context.Context, id int, reason string) error {
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		return failJobAndDependents(ctx, tx, id, reason, false)
+	})
+}
+
+// QuarantineJobsOptions for QuarantineJobs.
+// Only jobs in one of Queues with a name not in Names are quarantined, after they have been due to run for longer than
+// After. Nothing is quarantined if either Names or Queues is empty.
+type QuarantineJobsOptions struct {
+	After  time.Duration
+	Names  []string
+	Queues []string
+}
+
+// QuarantineJobs that have been due to run for a while, but have a name that isn't known in their queue.
+// They are moved to the failed jobs and marked as quarantined, together with all jobs depending on them.
+// Returns how many jobs were quarantined, not counting dependent jobs.
+func (d *Database) QuarantineJobs(ctx context.Context, opts QuarantineJobsOptions) (int, error) {
+	if len(opts.Names) == 0 || len(opts.Queues) == 0 {
+		return 0, nil
+	}
+
+	namesJSON, err := json.Marshal(opts.Names)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding job names")
+	}
+
+	queuesJSON, err := json.Marshal(opts.Queues)
+	if err != nil {
+		return 0, errors.Wrap(err, "error encoding queues")
+	}
+
+	var count int
+	err = d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		var ids []int
+		query := `
+			select id from jobs
+			where
+				queue in (select value from json_each(?)) and
+				name not in (select value from json_each(?)) and
+				run < ? and
+				` + jobVisible
+		if err := tx.SelectContext(ctx, &ids, query, string(queuesJSON), string(namesJSON), model.Time{T: time.Now().Add(-opts.After)}); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			// The job may already have been failed as a dependent of another quarantined job
+			var exists bool
+			if err := tx.GetContext(ctx, &exists, `select exists (select 1 from jobs where id = ?)`, id); err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if err := failJobAndDependents(ctx, tx, id, "no job registered with this name in the runner for its queue", true); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// failJobAndDependents by moving them to the failed jobs. Only the job itself is marked as quarantined, if given.
+func failJobAndDependents(ctx context.Context, tx *sqlx.Tx, id int, reason string, quarantined bool) error {
+	var dependents []int
+	query := `
+		with recursive dependents(id) as (
+			select job_id from job_dependencies where depends_on_id = ?
+			union
+			select job_dependencies.job_id from job_dependencies join dependents on job_dependencies.depends_on_id = dependents.id
+		)
+		select id from dependents`
+	if err := tx.SelectContext(ctx, &dependents, query, id); err != nil {
+		return err
+	}
+
+	if err := failJob(ctx, tx, id, reason, quarantined); err != nil {
+		return err
+	}
+	for _, dependentID := range dependents {
+		if err := failJob(ctx, tx, dependentID, fmt.Sprintf("dependency %v failed: %v", id, reason), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func failJob(ctx context.Context, tx *sqlx.Tx, id int, reason string, quarantined bool) error {
+	if err := saveJobResult(ctx, tx, id, reason); err != nil {
+		return err
+	}
+	query := `
+		insert into jobs_failed (name, queue, priority, payload, timeout, lease, attempts, max_attempts, batch, error, quarantined, job_id, created)
+		select name, queue, priority, payload, timeout, lease, attempts, max_attempts, batch, ?, ?, id, created from jobs where id = ?`
+	if _, err := tx.ExecContext(ctx, query, reason, quarantined, id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `delete from jobs where id = ?`, id)
+	return err
+}
+
+// GetFailedJobsOptions for GetFailedJobs.
+// If Name is not empty, only failed jobs with that name are returned.
+// Limit defaults to 100.
+type GetFailedJobsOptions struct {
+	Limit int
+	Name  string
+}
+
+// GetFailedJobs, most recently failed first.
+func (d *Database) GetFailedJobs(ctx context.Context, opts GetFailedJobsOptions) ([]model.FailedJob, error) {
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	var jobs []model.FailedJob
+	query := `
+		select * from jobs_failed
+		where ? = '' or name = ?
+		order by failed desc, id desc
+		limit ?`
+	err := d.DB.SelectContext(ctx, &jobs, query, opts.Name, opts.Name, opts.Limit)
+	return jobs, err
+}
+
+// GetFailedJob by ID. Returns nil if there is no such failed job.
+func (d *Database) GetFailedJob(ctx context.Context, id int) (*model.FailedJob, error) {
+	var job model.FailedJob
+	if err := d.DB.GetContext(ctx, &job, `select * from jobs_failed where id = ?`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RequeueFailedJob by moving it back to the jobs to run immediately, with its attempts reset.
+func (d *Database) RequeueFailedJob(ctx context.Context, id int) error {
+	return d.InTransaction(ctx, func(tx *sqlx.Tx) error {
+		query := `
+			insert into jobs (name, queue, priority, payload, timeout, lease, max_attempts, batch)
+			select name, queue, priority, payload, timeout, lease, max_attempts, batch from jobs_failed where id = ?`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `delete from jobs_failed where id = ?`, id)
+		return err
+	})
+}
+
+func (d *Database) DeleteFailedJob(ctx context.Context, id int) error {
+	_, err := d.DB.ExecContext(ctx, `delete from jobs_failed where id = ?`, id)
+	return err
+}
+
+// PurgeFailedJobs that failed before the given time, returning how many were deleted.
+func (d *Database) PurgeFailedJobs(ctx context.Context, before time.Time) (int, error) {
+	res, err := d.DB.ExecContext(ctx, `delete from jobs_failed where failed < ?`, model.Time{T: before})
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	return int(count), err
+}
+
+// ScheduleJob that recurs on the given schedule, to run next at the given time.
+// There is only ever one job per name with a schedule, so scheduling from several places at once is safe.
+// If the job is already scheduled with the same schedule, timeout and queue, nothing changes.
+// Of the job options, only the queue is used, see WithQueue.
+func (d *Database) ScheduleJob(ctx context.Context, name, schedule string, timeout time.Duration, run time.Time, opts ...JobOption) error {
+	if name == "" {
+		panic("job name cannot be empty")
+	}
+	if schedule == "" {
+		panic("job schedule cannot be empty")
+	}
+	o := ApplyJobOptions(opts...)
+
+	query := `
+		insert into jobs (name, payload, timeout, run, schedule, queue) values (?, '{}', ?, ?, ?, ?)
+		on conflict (name) where schedule != '' do update set
+			schedule = excluded.schedule,
+			timeout = excluded.timeout,
+			run = excluded.run,
+			queue = excluded.queue
+		where schedule != excluded.schedule or timeout != excluded.timeout or queue != excluded.queue`
+	_, err := d.DB.ExecContext(ctx, query, name, timeout, model.Time{T: run}, schedule, o.Queue)
+	return err
+}
+
+// GetJobQueues that have jobs in them, sorted by name.
+func (d *Database) GetJobQueues(ctx context.Context) ([]string, error) {
+	var queues []string
+	err := d.DB.SelectContext(ctx, &queues, `select distinct queue from jobs order by queue`)
+	return queues, err
+}
+
+// RescheduleJob to run next at the given time, resetting its attempts.
+func (d *Database) RescheduleJob(ctx context.Context, id int, run time.Time) error {
+	query := `update jobs set received = null, attempts = 0, run = ? where id = ?`
+	_, err := d.DB.ExecContext(ctx, query, model.Time{T: run}, id)
+	return err
+}
+
+// SubscribeJobCreated returns a channel that receives a value when jobs may have been created or become eligible
+// to run through this Database,
+// and a function to unsubscribe. Notifications are coalesced, so a single value can mean several new jobs.
+// Jobs created by other processes sharing the database are not notified about.
+func (d *Database) SubscribeJobCreated() (<-chan struct{}, func()) {
+	c := make(chan struct{}, 1)
+
+	d.subscribersLock.Lock()
+	d.subscribers[c] = struct{}{}
+	d.subscribersLock.Unlock()
+
+	return c, func() {
+		d.subscribersLock.Lock()
+		delete(d.subscribers, c)
+		d.subscribersLock.Unlock()
+	}
+}
+
+// notifyJobCreated to all subscribers, without blocking.
+func (d *Database) notifyJobCreated() {
+	d.subscribersLock.Lock()
+	defer d.subscribersLock.Unlock()
+
+	for c := range d.subscribers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// jobVisible is a condition for jobs that aren't received, or whose timeout or lease has expired since.
+// The durations are in nanoseconds, and are divided into fractional seconds, so sub-second timeouts and leases work.
+const jobVisible = `(
+	received is null or
+	strftime('%Y-%m-%dT%H:%M:%fZ', received, (case when lease > 0 then lease else timeout end/1000000000.0)||' seconds') <=
+		strftime('%Y-%m-%dT%H:%M:%fZ')
+)`
+
+// jobEligible is a condition for visible jobs that are due to run, and neither paused nor waiting for dependencies.
+// Rate limits are left out, since they depend on the options given to GetJob.
+const jobEligible = jobVisible + ` and
+	run <= strftime('%Y-%m-%dT%H:%M:%fZ') and
+	name not in (select name from jobs_paused) and
+	not exists (select 1 from job_dependencies where job_id = jobs.id)`
+
+// jobStateConditions for GetJobs.
+var jobStateConditions = map[model.JobState]string{
+	model.JobStatePending:   jobVisible + ` and run <= strftime('%Y-%m-%dT%H:%M:%fZ')`,
+	model.JobStateRunning:   `not ` + jobVisible,
+	model.JobStateScheduled: jobVisible + ` and run > strftime('%Y-%m-%dT%H:%M:%fZ')`,
+}
+
+// GetJobsOptions for GetJobs.
+// If Name is not empty, only jobs with that name are returned.
+// Limit defaults to 100.
+type GetJobsOptions struct {
+	Limit int
+	Name  string
+	State model.JobState
+}
+
+// GetJobs in the given state, ordered by when they run.
+func (d *Database) GetJobs(ctx context.Context, opts GetJobsOptions) ([]model.Job, error) {
+	condition, ok := jobStateConditions[opts.State]
+	if !ok {
+		return nil, errors.Newf("unknown job state %v", opts.State)
+	}
+	if opts.Limit == 0 {
+		opts.Limit = 100
+	}
+
+	var jobs []model.Job
+	query := `
+		select * from jobs
+		where (? = '' or name = ?) and ` + condition + `
+		order by run, id
+		limit ?`
+	err := d.DB.SelectContext(ctx, &jobs, query, opts.Name, opts.Name, opts.Limit)
+	return jobs, err
+}
+
+// GetJobCounts per job name and state, including failed jobs, sorted by name.
+func (d *Database) GetJobCounts(ctx context.Context) ([]model.JobCount, error) {
+	var counts []model.JobCount
+	query := `
+		select
+			name,
+			sum(` + jobStateConditions[model.JobStatePending] + `) as pending,
+			sum(` + jobStateConditions[model.JobStateRunning] + `) as running,
+			sum(` + jobStateConditions[model.JobStateScheduled] + `) as scheduled,
+			0 as failed
+		from jobs
+		group by name`
+	if err := d.DB.SelectContext(ctx, &counts, query); err != nil {
+		return nil, err
+	}
+
+	var failedCounts []struct {
+		Name  string
+		Count int
+	}
+	query = `select name, count(*) as count from jobs_failed group by name`
+	if err := d.DB.SelectContext(ctx, &failedCounts, query); err != nil {
+		return nil, err
+	}
+
+	for _, fc := range failedCounts {
+		var found bool
+		for i := range counts {
+			if counts[i].Name == fc.Name {
+				counts[i].Failed = fc.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			counts = append(counts, model.JobCount{Name: fc.Name, Failed: fc.Count})
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Name < counts[j].Name
+	})
+	return counts, nil
+}
+
+// RunJobNow instead of at its run time. Jobs that are running are not changed.
+func (d *Database) RunJobNow(ctx context.Context, id int) error {
+	query := `update jobs set run = strftime('%Y-%m-%dT%H:%M:%fZ') where id = ? and ` + jobVisible
+	if _, err := d.DB.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	d.notifyJobCreated()
+	return nil
+}
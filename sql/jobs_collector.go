@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/maragudk/service/model"
+)
+
+// jobsCollector reports the number of pending jobs and the age of the oldest pending job per name and queue,
+// straight from the jobs table. Pending jobs are eligible to be received by GetJob, except for rate limits, so jobs
+// that are paused or waiting for dependencies are not included.
+// It also reports the number of quarantined jobs per name, see QuarantineJobs.
+type jobsCollector struct {
+	db          *Database
+	pending     *prometheus.Desc
+	oldestAge   *prometheus.Desc
+	quarantined *prometheus.Desc
+	scrapeTime  time.Duration
+}
+
+func newJobsCollector(d *Database) *jobsCollector {
+	return &jobsCollector{
+		db: d,
+		pending: prometheus.NewDesc("app_jobs_pending",
+			"The number of jobs that are eligible to run but not received yet.", []string{"name", "queue"}, nil),
+		oldestAge: prometheus.NewDesc("app_jobs_oldest_pending_age_seconds",
+			"How long the oldest pending job has been due to run.", []string{"name", "queue"}, nil),
+		quarantined: prometheus.NewDesc("app_jobs_quarantined",
+			"The number of failed jobs that were quarantined because no runner had them registered.", []string{"name"}, nil),
+		scrapeTime: 5 * time.Second,
+	}
+}
+
+func (c *jobsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pending
+	ch <- c.oldestAge
+	ch <- c.quarantined
+}
+
+func (c *jobsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.scrapeTime)
+	defer cancel()
+
+	var rows []struct {
+		Name   string
+		Queue  string
+		Count  int
+		Oldest model.Time
+	}
+	query := `
+		select name, queue, count(*) as count, min(run) as oldest
+		from jobs
+		where ` + jobEligible + `
+		group by name, queue`
+	if err := c.db.DB.SelectContext(ctx, &rows, query); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.pending, err)
+		ch <- prometheus.NewInvalidMetric(c.oldestAge, err)
+		return
+	}
+
+	now := time.Now()
+	for _, r := range rows {
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(r.Count), r.Name, r.Queue)
+		ch <- prometheus.MustNewConstMetric(c.oldestAge, prometheus.GaugeValue, now.Sub(r.Oldest.T).Seconds(), r.Name, r.Queue)
+	}
+
+	var quarantined []struct {
+		Name  string
+		Count int
+	}
+	query = `select name, count(*) as count from jobs_failed where quarantined = 1 group by name`
+	if err := c.db.DB.SelectContext(ctx, &quarantined, query); err != nil {
+		ch <- prometheus.NewInvalidMetric(c.quarantined, err)
+		return
+	}
+
+	for _, r := range quarantined {
+		ch <- prometheus.MustNewConstMetric(c.quarantined, prometheus.GaugeValue, float64(r.Count), r.Name)
+	}
+}
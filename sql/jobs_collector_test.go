@@ -0,0 +1,71 @@
+package sql_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
+)
+
+func TestJobsCollector(t *testing.T) {
+	t.Run("registers a collector for pending jobs", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+
+		db := sql.NewDatabase(sql.NewDatabaseOptions{
+			URL:                ":memory:",
+			MaxOpenConnections: 1,
+			MaxIdleConnections: 1,
+			Metrics:            registry,
+		})
+		err := db.Connect()
+		require.NoError(t, err)
+		err = db.MigrateUp(context.Background())
+		require.NoError(t, err)
+
+		_, err = db.CreateJobForLater(context.Background(), "send-email", model.Map{}, time.Minute, -time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "send-email", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "other", model.Map{}, time.Minute, sql.WithQueue("other"))
+		require.NoError(t, err)
+		// Jobs for later are not pending yet
+		_, err = db.CreateJobForLater(context.Background(), "later", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+		// Neither are paused jobs, or jobs waiting for dependencies
+		err = db.PauseJobs(context.Background(), "paused")
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "paused", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		laterID, err := db.CreateJobForLater(context.Background(), "later", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "dependent", model.Map{}, time.Minute, sql.WithDependencies(laterID))
+		require.NoError(t, err)
+
+		metrics, err := registry.Gather()
+		require.NoError(t, err)
+
+		var found bool
+		for _, metric := range metrics {
+			switch metric.GetName() {
+			case "app_jobs_pending":
+				found = true
+				require.Len(t, metric.Metric, 2)
+				require.Equal(t, "other", metric.Metric[0].Label[0].GetValue())
+				require.Equal(t, "other", metric.Metric[0].Label[1].GetValue())
+				require.Equal(t, float64(1), metric.Metric[0].Gauge.GetValue())
+				require.Equal(t, "send-email", metric.Metric[1].Label[0].GetValue())
+				require.Equal(t, "default", metric.Metric[1].Label[1].GetValue())
+				require.Equal(t, float64(2), metric.Metric[1].Gauge.GetValue())
+			case "app_jobs_oldest_pending_age_seconds":
+				require.Len(t, metric.Metric, 2)
+				require.InDelta(t, 60, metric.Metric[1].Gauge.GetValue(), 1)
+			}
+		}
+		require.True(t, found)
+	})
+}
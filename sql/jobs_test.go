@@ -5,9 +5,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/require"
 
 	"github.com/maragudk/service/model"
+	"github.com/maragudk/service/sql"
 	"github.com/maragudk/service/sqltest"
 )
 
@@ -15,7 +17,7 @@ func TestDatabase_CreateJob(t *testing.T) {
 	t.Run("can create job", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
 		require.NoError(t, err)
 
 		var jobs []model.Job
@@ -24,45 +26,172 @@ func TestDatabase_CreateJob(t *testing.T) {
 
 		require.Len(t, jobs, 1)
 		require.Equal(t, "test", jobs[0].Name)
-		require.Equal(t, model.Map{}, jobs[0].Payload)
+		require.Equal(t, model.JSON(`{}`), jobs[0].Payload)
 		require.Equal(t, time.Minute, jobs[0].Timeout)
 		require.WithinDuration(t, time.Now(), jobs[0].Run.T, time.Second)
 		require.Nil(t, jobs[0].Received)
+		require.Equal(t, 0, jobs[0].Attempts)
+		require.Equal(t, 3, jobs[0].MaxAttempts)
 		require.WithinDuration(t, time.Now(), jobs[0].Created.T, time.Second)
 		require.WithinDuration(t, time.Now(), jobs[0].Updated.T, time.Second)
 	})
+
+	t.Run("returns the existing job ID if a job with the same key exists", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		id2, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+		require.Equal(t, id1, id2)
+
+		id3, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-2"))
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id3)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+
+	t.Run("creates a new job with the same key after the first is deleted", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		err = db.DeleteJob(context.Background(), id1)
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("creates a new job with the same key while the first is running", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, id1, job.ID)
+
+		id2, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id2)
+
+		id3, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+		require.Equal(t, id2, id3)
+	})
+
+	t.Run("scopes keys by name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("user-1"))
+		require.NoError(t, err)
+
+		id2, err := db.CreateJob(context.Background(), "other", model.Map{}, time.Minute, sql.WithKey("user-1"))
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id2)
+	})
+
+	t.Run("creates jobs without keys every time", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		id2, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		require.NotEqual(t, id1, id2)
+	})
+
+	t.Run("panics with an empty name and leaves the database usable", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		require.Panics(t, func() {
+			_, _ = db.CreateJob(context.Background(), "", model.Map{}, time.Minute)
+		})
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+	})
+
+	t.Run("errors with an empty name in a transaction", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			_, err := db.CreateJobTx(context.Background(), tx, "", model.Map{}, time.Minute, 0)
+			return err
+		})
+		require.Error(t, err)
+
+		_, err = db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+	})
 }
 
 func TestDatabase_GetJob(t *testing.T) {
 	t.Run("gets job to run now", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
 		require.NoError(t, err)
 
-		job, err := db.GetJob(context.Background())
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.NotNil(t, job)
 
-		require.Equal(t, model.Map{}, job.Payload)
+		require.Equal(t, model.JSON(`{}`), job.Payload)
 		require.Equal(t, time.Minute, job.Timeout)
 		require.WithinDuration(t, time.Now(), job.Run.T, time.Second)
 		require.WithinDuration(t, time.Now(), job.Received.T, time.Second)
+		require.Equal(t, 1, job.Attempts)
 		require.WithinDuration(t, time.Now(), job.Created.T, time.Second)
 		require.WithinDuration(t, time.Now(), job.Updated.T, time.Second)
 	})
 
+	t.Run("gets a job again after a sub-second timeout", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, 500*time.Millisecond)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		time.Sleep(600 * time.Millisecond)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
 	t.Run("doesn't get a job twice immediately", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
 		require.NoError(t, err)
 
-		job, err := db.GetJob(context.Background())
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.NotNil(t, job)
 
-		job, err = db.GetJob(context.Background())
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.Nil(t, job)
 	})
@@ -70,16 +199,16 @@ func TestDatabase_GetJob(t *testing.T) {
 	t.Run("returns the job again after timeout", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, time.Millisecond)
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Millisecond)
 		require.NoError(t, err)
 
-		job, err := db.GetJob(context.Background())
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.NotNil(t, job)
 
 		time.Sleep(time.Millisecond)
 
-		job, err = db.GetJob(context.Background())
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.NotNil(t, job)
 	})
@@ -87,31 +216,1034 @@ func TestDatabase_GetJob(t *testing.T) {
 	t.Run("doesn't get a job created for later", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, time.Minute)
+		_, err := db.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, time.Minute)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+
+	t.Run("only gets jobs from the given queue", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithQueue("marketing"))
 		require.NoError(t, err)
 
-		job, err := db.GetJob(context.Background())
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.Nil(t, job)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{Queue: "marketing"})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "marketing", job.Queue)
+	})
+
+	t.Run("gets jobs with higher priority first, then oldest first", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "newsletter", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "password-reset-1", model.Map{}, time.Minute, sql.WithPriority(10))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "password-reset-2", model.Map{}, time.Minute, sql.WithPriority(10))
+		require.NoError(t, err)
+
+		for _, name := range []string{"password-reset-1", "password-reset-2", "newsletter"} {
+			job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+			require.NoError(t, err)
+			require.NotNil(t, job)
+			require.Equal(t, name, job.Name)
+		}
 	})
 }
 
-func TestDatabase_DeleteJob(t *testing.T) {
-	t.Run("deletes a job", func(t *testing.T) {
+func TestDatabase_GetJob_rateLimits(t *testing.T) {
+	t.Run("doesn't get jobs over the rate limit", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for _, name := range []string{"send-email", "send-email", "send-email", "other"} {
+			_, err := db.CreateJob(context.Background(), name, model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 2, Per: time.Hour}}}
+
+		var names []string
+		for {
+			job, err := db.GetJob(context.Background(), opts)
+			require.NoError(t, err)
+			if job == nil {
+				break
+			}
+			names = append(names, job.Name)
+		}
+		require.Equal(t, []string{"send-email", "send-email", "other"}, names)
+
+		// Without the rate limit, the last job is received
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "send-email", job.Name)
+	})
+
+	t.Run("gets jobs again after the interval", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for i := 0; i < 2; i++ {
+			_, err := db.CreateJob(context.Background(), "send-email", model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 1, Per: 100 * time.Millisecond}}}
+
+		job, err := db.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		job, err = db.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		time.Sleep(150 * time.Millisecond)
+
+		job, err = db.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+
+	t.Run("only records receiving jobs with a rate limit", func(t *testing.T) {
 		db := sqltest.CreateDatabase(t)
 
-		err := db.CreateJob(context.Background(), "test", model.Map{}, 0)
+		for _, name := range []string{"send-email", "other"} {
+			_, err := db.CreateJob(context.Background(), name, model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 2, Per: time.Hour}}}
+
+		for i := 0; i < 2; i++ {
+			job, err := db.GetJob(context.Background(), opts)
+			require.NoError(t, err)
+			require.NotNil(t, job)
+		}
+
+		var names []string
+		err := db.DB.Select(&names, `select name from job_claims`)
 		require.NoError(t, err)
+		require.Equal(t, []string{"send-email"}, names)
+	})
+
+	t.Run("doesn't count released jobs against the rate limit", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for i := 0; i < 2; i++ {
+			_, err := db.CreateJob(context.Background(), "send-email", model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
 
-		job, err := db.GetJob(context.Background())
+		opts := sql.GetJobOptions{RateLimits: []sql.RateLimit{{Name: "send-email", Limit: 1, Per: time.Hour}}}
+
+		job, err := db.GetJob(context.Background(), opts)
 		require.NoError(t, err)
 		require.NotNil(t, job)
 
-		err = db.DeleteJob(context.Background(), job.ID)
+		err = db.ReleaseJob(context.Background(), job.ID)
 		require.NoError(t, err)
 
-		job, err = db.GetJob(context.Background())
+		job, err = db.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		job, err = db.GetJob(context.Background(), opts)
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestDatabase_GetJob_names(t *testing.T) {
+	t.Run("only gets jobs with the given names", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for _, name := range []string{"new-job", "test"} {
+			_, err := db.CreateJob(context.Background(), name, model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{Names: []string{"test", "other"}})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "test", job.Name)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{Names: []string{"test", "other"}})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestDatabase_GetJob_dependencies(t *testing.T) {
+	t.Run("gets a job only after its dependency has finished", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		aID, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "a", job.Name)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		err = db.DeleteJob(context.Background(), aID)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "b", job.Name)
+	})
+
+	t.Run("gets a job only after all its dependencies have finished", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		a1ID, err := db.CreateJob(context.Background(), "a1", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		a2ID, err := db.CreateJob(context.Background(), "a2", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "c", model.Map{}, time.Minute, sql.WithDependencies(a1ID, a2ID))
+		require.NoError(t, err)
+
+		err = db.DeleteJob(context.Background(), a1ID)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "a2", job.Name)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		err = db.DeleteJob(context.Background(), a2ID)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "c", job.Name)
+	})
+
+	t.Run("ignores dependencies that have already finished", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		aID, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		err = db.DeleteJob(context.Background(), aID)
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "b", job.Name)
+		require.NotEqual(t, aID, job.ID)
+	})
+
+	t.Run("errors if a dependency has already failed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		aID, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		err = db.FailJob(context.Background(), aID, "oh no")
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.Error(t, err)
+
+		_, err = db.CreateJobs(context.Background(), []sql.BatchJob{{Name: "b", Payload: model.Map{}, Timeout: time.Minute}},
+			sql.WithDependencies(aID))
+		require.Error(t, err)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+}
+
+func TestDatabase_RetryJob(t *testing.T) {
+	t.Run("makes a job available again after the delay", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithMaxAttempts(5))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 5, job.MaxAttempts)
+		id := job.ID
+
+		err = db.RetryJob(context.Background(), id, time.Minute)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
 		require.NoError(t, err)
 		require.Nil(t, job)
+
+		err = db.RetryJob(context.Background(), id, 0)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 2, job.Attempts)
+	})
+}
+
+func TestDatabase_RetryJob_key(t *testing.T) {
+	t.Run("deletes the job if a pending job with the same key exists, moving its dependents", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id1, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "dependent", model.Map{}, time.Minute, sql.WithDependencies(id1))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, id1, job.ID)
+
+		id2, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithKey("sync-user-1"))
+		require.NoError(t, err)
+
+		err = db.RetryJob(context.Background(), id1, 0)
+		require.NoError(t, err)
+
+		var ids []int
+		err = db.DB.Select(&ids, `select id from jobs where name = 'test'`)
+		require.NoError(t, err)
+		require.Equal(t, []int{id2}, ids)
+
+		var dependsOn []int
+		err = db.DB.Select(&dependsOn, `select depends_on_id from job_dependencies`)
+		require.NoError(t, err)
+		require.Equal(t, []int{id2}, dependsOn)
+	})
+}
+
+func TestDatabase_ReleaseJob(t *testing.T) {
+	t.Run("makes a received job available again immediately, without counting the attempt", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Hour)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		err = db.ReleaseJob(context.Background(), job.ID)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 1, job.Attempts)
+	})
+}
+
+func TestDatabase_DeleteJob(t *testing.T) {
+	t.Run("deletes a job", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, 0)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		err = db.DeleteJob(context.Background(), job.ID)
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+}
+
+func TestDatabase_FailJob(t *testing.T) {
+	t.Run("moves a job to the failed jobs", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{"foo": "bar"}, time.Minute)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		err = db.FailJob(context.Background(), job.ID, "oh no")
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+
+		failedJob, err := db.GetFailedJob(context.Background(), failedJobs[0].ID)
+		require.NoError(t, err)
+		require.NotNil(t, failedJob)
+		require.Equal(t, "test", failedJob.Name)
+		require.Equal(t, model.JSON(`{"foo":"bar"}`), failedJob.Payload)
+		require.Equal(t, time.Minute, failedJob.Timeout)
+		require.Equal(t, 1, failedJob.Attempts)
+		require.Equal(t, 3, failedJob.MaxAttempts)
+		require.Equal(t, "oh no", failedJob.Error)
+		require.WithinDuration(t, time.Now(), failedJob.Created.T, time.Second)
+		require.WithinDuration(t, time.Now(), failedJob.Failed.T, time.Second)
+	})
+
+	t.Run("fails all jobs depending on it", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		aID, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		bID, err := db.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "c", model.Map{}, time.Minute, sql.WithDependencies(bID))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "other", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		err = db.FailJob(context.Background(), aID, "oh no")
+		require.NoError(t, err)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 3)
+
+		errs := map[string]string{}
+		for _, j := range failedJobs {
+			errs[j.Name] = j.Error
+		}
+		require.Equal(t, "oh no", errs["a"])
+		require.Contains(t, errs["b"], "oh no")
+		require.Contains(t, errs["c"], "oh no")
+
+		var names []string
+		err = db.DB.Select(&names, `select name from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, []string{"other"}, names)
+	})
+}
+
+func TestDatabase_QuarantineJobs(t *testing.T) {
+	t.Run("moves jobs with unknown names that have been due for a while to the failed jobs", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		unknownID, err := db.CreateJobForLater(context.Background(), "unknown", model.Map{}, time.Minute, -time.Hour)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "dependent", model.Map{}, time.Minute, sql.WithDependencies(unknownID))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "unknown", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, -time.Hour)
+		require.NoError(t, err)
+		_, err = db.CreateJobForLater(context.Background(), "unknown", model.Map{}, time.Minute, -time.Hour, sql.WithQueue("other"))
+		require.NoError(t, err)
+
+		count, err := db.QuarantineJobs(context.Background(), sql.QuarantineJobsOptions{
+			After:  30 * time.Minute,
+			Names:  []string{"test", "dependent"},
+			Queues: []string{sql.DefaultQueue},
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 2)
+		require.Equal(t, "dependent", failedJobs[0].Name)
+		require.False(t, failedJobs[0].Quarantined)
+		require.Equal(t, "unknown", failedJobs[1].Name)
+		require.True(t, failedJobs[1].Quarantined)
+
+		var names []string
+		err = db.DB.Select(&names, `select name from jobs order by id`)
+		require.NoError(t, err)
+		require.Equal(t, []string{"unknown", "test", "unknown"}, names)
+	})
+
+	t.Run("quarantines nothing without names or queues", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJobForLater(context.Background(), "unknown", model.Map{}, time.Minute, -time.Hour)
+		require.NoError(t, err)
+
+		count, err := db.QuarantineJobs(context.Background(), sql.QuarantineJobsOptions{Queues: []string{sql.DefaultQueue}})
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		count, err = db.QuarantineJobs(context.Background(), sql.QuarantineJobsOptions{Names: []string{"test"}})
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+}
+
+func TestDatabase_GetFailedJobs(t *testing.T) {
+	t.Run("gets failed jobs by name, most recently failed first, up to the limit", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for _, name := range []string{"test", "other", "test", "test"} {
+			id, err := db.CreateJob(context.Background(), name, model.Map{}, time.Minute)
+			require.NoError(t, err)
+			err = db.FailJob(context.Background(), id, "oh no")
+			require.NoError(t, err)
+		}
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{Limit: 2, Name: "test"})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 2)
+		require.Equal(t, "test", failedJobs[0].Name)
+		require.Equal(t, 4, failedJobs[0].JobID)
+		require.Equal(t, "test", failedJobs[1].Name)
+		require.Equal(t, 3, failedJobs[1].JobID)
+
+		failedJobs, err = db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 4)
+	})
+}
+
+func TestDatabase_GetFailedJob(t *testing.T) {
+	t.Run("returns nil if no such failed job", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		failedJob, err := db.GetFailedJob(context.Background(), 1)
+		require.NoError(t, err)
+		require.Nil(t, failedJob)
+	})
+}
+
+func TestDatabase_RequeueFailedJob(t *testing.T) {
+	t.Run("moves a failed job back to the jobs with attempts reset", func(t *testing.T) {
+		db := createFailedJob(t)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+
+		err = db.RequeueFailedJob(context.Background(), failedJobs[0].ID)
+		require.NoError(t, err)
+
+		failedJobs, err = db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 0)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "test", job.Name)
+		require.Equal(t, 1, job.Attempts)
+	})
+}
+
+func TestDatabase_DeleteFailedJob(t *testing.T) {
+	t.Run("deletes a failed job", func(t *testing.T) {
+		db := createFailedJob(t)
+
+		failedJobs, err := db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 1)
+
+		err = db.DeleteFailedJob(context.Background(), failedJobs[0].ID)
+		require.NoError(t, err)
+
+		failedJobs, err = db.GetFailedJobs(context.Background(), sql.GetFailedJobsOptions{})
+		require.NoError(t, err)
+		require.Len(t, failedJobs, 0)
+	})
+}
+
+func TestDatabase_PurgeFailedJobs(t *testing.T) {
+	t.Run("deletes failed jobs that failed before the given time", func(t *testing.T) {
+		db := createFailedJob(t)
+
+		count, err := db.PurgeFailedJobs(context.Background(), time.Now().Add(-time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+
+		count, err = db.PurgeFailedJobs(context.Background(), time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+}
+
+func createFailedJob(t *testing.T) *sql.Database {
+	t.Helper()
+
+	db := sqltest.CreateDatabase(t)
+
+	_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+	require.NoError(t, err)
+
+	job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	err = db.FailJob(context.Background(), job.ID, "oh no")
+	require.NoError(t, err)
+
+	return db
+}
+
+func TestDatabase_ScheduleJob(t *testing.T) {
+	t.Run("creates only one job per name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		run := time.Now().Add(time.Hour)
+		err := db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, run)
+		require.NoError(t, err)
+
+		err = db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, run.Add(time.Hour))
+		require.NoError(t, err)
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, "test", jobs[0].Name)
+		require.Equal(t, "@hourly", jobs[0].Schedule)
+		require.Equal(t, time.Minute, jobs[0].Timeout)
+		require.WithinDuration(t, run, jobs[0].Run.T, time.Millisecond)
+	})
+
+	t.Run("updates the job if the schedule changed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		run := time.Now().Add(time.Minute)
+		err = db.ScheduleJob(context.Background(), "test", "* * * * *", time.Minute, run)
+		require.NoError(t, err)
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, "* * * * *", jobs[0].Schedule)
+		require.WithinDuration(t, run, jobs[0].Run.T, time.Millisecond)
+	})
+
+	t.Run("does not interfere with unscheduled jobs with the same name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		err = db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+	})
+
+	t.Run("puts the job in the given queue, and moves it if the queue changed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		err = db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, time.Now().Add(time.Hour),
+			sql.WithQueue("marketing"))
+		require.NoError(t, err)
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, "marketing", jobs[0].Queue)
+	})
+}
+
+func TestDatabase_GetJobQueues(t *testing.T) {
+	t.Run("gets the distinct queues that have jobs, sorted by name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		queues, err := db.GetJobQueues(context.Background())
+		require.NoError(t, err)
+		require.Len(t, queues, 0)
+
+		for _, queue := range []string{"marketing", "default", "marketing"} {
+			_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithQueue(queue))
+			require.NoError(t, err)
+		}
+
+		queues, err = db.GetJobQueues(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{"default", "marketing"}, queues)
+	})
+}
+
+func TestDatabase_RescheduleJob(t *testing.T) {
+	t.Run("makes the job available at the next time with attempts reset", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.ScheduleJob(context.Background(), "test", "@hourly", time.Minute, time.Now())
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, 1, job.Attempts)
+
+		err = db.RescheduleJob(context.Background(), job.ID, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		var jobs []model.Job
+		err = db.DB.Select(&jobs, `select * from jobs`)
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		require.Equal(t, 0, jobs[0].Attempts)
+		require.Nil(t, jobs[0].Received)
+	})
+}
+
+func TestDatabase_SubscribeJobCreated(t *testing.T) {
+	t.Run("notifies subscribers when a job is created", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		c, unsubscribe := db.SubscribeJobCreated()
+		defer unsubscribe()
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		select {
+		case <-c:
+		default:
+			t.Fatal("no notification")
+		}
+	})
+
+	t.Run("notifies subscribers after a transaction is committed", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		c, unsubscribe := db.SubscribeJobCreated()
+		defer unsubscribe()
+
+		err := db.InTransaction(context.Background(), func(tx *sqlx.Tx) error {
+			_, err := db.CreateJobTx(context.Background(), tx, "test", model.Map{}, time.Minute, 0)
+			require.NoError(t, err)
+			require.Len(t, c, 0)
+			return err
+		})
+		require.NoError(t, err)
+
+		select {
+		case <-c:
+		default:
+			t.Fatal("no notification")
+		}
+	})
+
+	t.Run("does not notify after unsubscribing", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		c, unsubscribe := db.SubscribeJobCreated()
+		unsubscribe()
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		require.Len(t, c, 0)
+	})
+}
+
+func TestDatabase_ExtendJobLease(t *testing.T) {
+	t.Run("keeps a job with a lease invisible", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithLease(time.Second))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, time.Second, job.Lease)
+		id := job.ID
+
+		time.Sleep(600 * time.Millisecond)
+
+		received, err := db.ExtendJobLease(context.Background(), id, job.Received.T)
+		require.NoError(t, err)
+		require.NotNil(t, received)
+		require.True(t, received.T.After(job.Received.T))
+
+		time.Sleep(600 * time.Millisecond)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		time.Sleep(600 * time.Millisecond)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, id, job.ID)
+	})
+
+	t.Run("doesn't extend the lease of a job received again since", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute, sql.WithLease(time.Second))
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		// As if the lease expired and the job was received again elsewhere
+		again := model.Time{T: job.Received.T.Add(2 * time.Second)}
+		_, err = db.DB.Exec(`update jobs set received = ? where id = ?`, again, job.ID)
+		require.NoError(t, err)
+
+		received, err := db.ExtendJobLease(context.Background(), job.ID, job.Received.T)
+		require.NoError(t, err)
+		require.Nil(t, received)
+
+		var r model.Time
+		err = db.DB.Get(&r, `select received from jobs where id = ?`, job.ID)
+		require.NoError(t, err)
+		require.True(t, again.T.Equal(r.T))
+	})
+}
+
+func TestDatabase_CancelJob(t *testing.T) {
+	t.Run("deletes a pending job", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		err = db.CancelJob(context.Background(), id)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+	})
+
+	t.Run("deletes all jobs depending on it", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		aID, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		bID, err := db.CreateJob(context.Background(), "b", model.Map{}, time.Minute, sql.WithDependencies(aID))
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "c", model.Map{}, time.Minute, sql.WithDependencies(bID))
+		require.NoError(t, err)
+
+		err = db.CancelJob(context.Background(), aID)
+		require.NoError(t, err)
+
+		var count int
+		err = db.DB.Get(&count, `select count(*) from jobs`)
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+}
+
+func TestDatabase_IsJobCancelled(t *testing.T) {
+	t.Run("reports whether a received job has been cancelled", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJob(context.Background(), "test", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Equal(t, id, job.ID)
+
+		cancelled, err := db.IsJobCancelled(context.Background(), id)
+		require.NoError(t, err)
+		require.False(t, cancelled)
+
+		err = db.CancelJob(context.Background(), id)
+		require.NoError(t, err)
+
+		cancelled, err = db.IsJobCancelled(context.Background(), id)
+		require.NoError(t, err)
+		require.True(t, cancelled)
+	})
+}
+
+func TestDatabase_CancelJobsByName(t *testing.T) {
+	t.Run("deletes all jobs with the name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		for _, name := range []string{"send-newsletter", "send-newsletter", "other"} {
+			_, err := db.CreateJob(context.Background(), name, model.Map{}, time.Minute)
+			require.NoError(t, err)
+		}
+
+		count, err := db.CancelJobsByName(context.Background(), "send-newsletter")
+		require.NoError(t, err)
+		require.Equal(t, 2, count)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, "other", job.Name)
+	})
+}
+
+func TestDatabase_PauseJobs(t *testing.T) {
+	t.Run("pauses and resumes jobs by name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "send-newsletter", model.Map{}, time.Minute)
+		require.NoError(t, err)
+
+		err = db.PauseJobs(context.Background(), "send-newsletter")
+		require.NoError(t, err)
+		// Pausing twice is fine
+		err = db.PauseJobs(context.Background(), "send-newsletter")
+		require.NoError(t, err)
+
+		names, err := db.GetPausedJobNames(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []string{"send-newsletter"}, names)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.Nil(t, job)
+
+		err = db.ResumeJobs(context.Background(), "send-newsletter")
+		require.NoError(t, err)
+
+		names, err = db.GetPausedJobNames(context.Background())
+		require.NoError(t, err)
+		require.Len(t, names, 0)
+
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+	})
+}
+
+func TestDatabase_GetJobs(t *testing.T) {
+	t.Run("gets jobs by state and name", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "running", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		_, err = db.CreateJob(context.Background(), "pending", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJob(context.Background(), "other", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJobForLater(context.Background(), "scheduled", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+
+		tests := []struct {
+			state    model.JobState
+			name     string
+			expected []string
+		}{
+			{model.JobStatePending, "", []string{"pending", "other"}},
+			{model.JobStatePending, "pending", []string{"pending"}},
+			{model.JobStateRunning, "", []string{"running"}},
+			{model.JobStateScheduled, "", []string{"scheduled"}},
+		}
+		for _, test := range tests {
+			jobs, err := db.GetJobs(context.Background(), sql.GetJobsOptions{Name: test.name, State: test.state})
+			require.NoError(t, err)
+
+			var names []string
+			for _, j := range jobs {
+				names = append(names, j.Name)
+			}
+			require.Equal(t, test.expected, names, test.state)
+		}
+	})
+
+	t.Run("errors on unknown state", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.GetJobs(context.Background(), sql.GetJobsOptions{State: "foo"})
+		require.Error(t, err)
+	})
+}
+
+func TestDatabase_GetJobCounts(t *testing.T) {
+	t.Run("counts jobs per name and state", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+
+		_, err = db.CreateJob(context.Background(), "a", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		_, err = db.CreateJobForLater(context.Background(), "a", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+
+		_, err = db.CreateJob(context.Background(), "b", model.Map{}, time.Minute)
+		require.NoError(t, err)
+		job, err = db.GetJob(context.Background(), sql.GetJobOptions{Names: []string{"b"}})
+		require.NoError(t, err)
+		err = db.FailJob(context.Background(), job.ID, "oh no")
+		require.NoError(t, err)
+
+		counts, err := db.GetJobCounts(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []model.JobCount{
+			{Name: "a", Pending: 1, Running: 1, Scheduled: 1},
+			{Name: "b", Failed: 1},
+		}, counts)
+	})
+}
+
+func TestDatabase_RunJobNow(t *testing.T) {
+	t.Run("makes a job for later run now", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		id, err := db.CreateJobForLater(context.Background(), "test", model.Map{}, time.Minute, time.Hour)
+		require.NoError(t, err)
+
+		err = db.RunJobNow(context.Background(), id)
+		require.NoError(t, err)
+
+		job, err := db.GetJob(context.Background(), sql.GetJobOptions{})
+		require.NoError(t, err)
+		require.NotNil(t, job)
+		require.Equal(t, id, job.ID)
 	})
 }
@@ -0,0 +1,160 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/maragudk/errors"
+
+	"github.com/maragudk/service/model"
+)
+
+// AcquireLease with the given name for the owner, until the given duration has passed.
+// If the owner already holds the lease, it's renewed. Returns nil if another owner holds the lease and it hasn't expired.
+// The token of the lease increases every time it changes owner. It's not checked by any writes in this package, but
+// callers can store and compare it to fence their own writes against owners that have lost the lease.
+func (d *Database) AcquireLease(ctx context.Context, name, owner string, duration time.Duration) (*model.Lease, error) {
+	if name == "" {
+		panic("lease name cannot be empty")
+	}
+	if owner == "" {
+		panic("lease owner cannot be empty")
+	}
+
+	var l model.Lease
+	query := `
+		insert into leases (name, owner, expires, token) values (?, ?, ?, 1)
+		on conflict (name) do update set
+			owner = excluded.owner,
+			expires = excluded.expires,
+			token = case when owner = excluded.owner then token else token + 1 end
+		where owner = excluded.owner or expires <= strftime('%Y-%m-%dT%H:%M:%fZ')
+		returning *`
+	if err := d.DB.GetContext(ctx, &l, query, name, owner, model.Time{T: time.Now().Add(duration)}); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+// ReleaseLease with the given name, if the owner holds it, so another owner can acquire it right away.
+func (d *Database) ReleaseLease(ctx context.Context, name, owner string) error {
+	query := `update leases set owner = '', expires = strftime('%Y-%m-%dT%H:%M:%fZ') where name = ? and owner = ?`
+	_, err := d.DB.ExecContext(ctx, query, name, owner)
+	return err
+}
+
+// Lead by running fn whenever the owner holds the lease with the given name, until ctx is cancelled.
+// The lease is only acquired while this instance is the primary, see IsPrimary.
+// It's acquired for the given duration, and renewed every third of it while fn runs.
+// Renewal errors are retried until less than a third of the duration is left before the lease expires.
+// If the lease cannot be renewed by then, or it's lost, the context given to fn is cancelled,
+// and fn must stop before the lease expires.
+// The lease is released after fn returns. Lead blocks until ctx is cancelled and fn has returned.
+func (d *Database) Lead(ctx context.Context, name, owner string, duration time.Duration, fn func(ctx context.Context)) {
+	interval := duration / 3
+
+	for {
+		var lease *model.Lease
+		primary, _, err := d.IsPrimary()
+		if err != nil {
+			d.log.Println("Error checking whether primary:", err)
+		}
+		if primary {
+			lease, err = d.AcquireLease(ctx, name, owner, duration)
+			if err != nil && ctx.Err() == nil {
+				d.log.Println("Error acquiring lease:", err)
+			}
+		}
+
+		if lease != nil {
+			d.log.Println("Acquired lease", name, "with token", lease.Token)
+			d.leadUntilLost(ctx, lease, duration, fn)
+
+			// Use context.Background, so the lease is also released after ctx is cancelled
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := d.ReleaseLease(releaseCtx, name, owner); err != nil {
+				d.log.Println("Error releasing lease, it will expire:", err)
+			}
+			cancel()
+			d.log.Println("Released lease", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// leadUntilLost runs fn while renewing the lease, until fn returns, the lease is lost, or ctx is cancelled.
+func (d *Database) leadUntilLost(ctx context.Context, lease *model.Lease, duration time.Duration, fn func(ctx context.Context)) {
+	name, owner, expires := lease.Name, lease.Owner, lease.Expires.T
+	interval := duration / 3
+
+	// retryable reports whether there's time to retry renewing the lease at the next tick, before it's too close to expiring
+	retryable := func() bool {
+		return time.Until(expires) > interval
+	}
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leadCtx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-leadCtx.Done():
+			<-done
+			return
+		case <-ticker.C:
+			primary, _, err := d.IsPrimary()
+			if err != nil {
+				if retryable() {
+					d.log.Println("Error checking whether primary, retrying:", err)
+					continue
+				}
+				d.log.Println("Error checking whether primary, stopping:", err)
+				cancel()
+				continue
+			}
+			if !primary {
+				d.log.Println("Not primary anymore, stopping")
+				cancel()
+				continue
+			}
+			lease, err := d.AcquireLease(leadCtx, name, owner, duration)
+			if err != nil {
+				if leadCtx.Err() != nil {
+					continue
+				}
+				if retryable() {
+					d.log.Println("Error renewing lease, retrying:", err)
+					continue
+				}
+				d.log.Println("Error renewing lease, stopping:", err)
+				cancel()
+				continue
+			}
+			if lease == nil {
+				d.log.Println("Lost lease", name, "to another owner, stopping")
+				cancel()
+				continue
+			}
+			expires = lease.Expires.T
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package sql_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestDatabase_AcquireLease(t *testing.T) {
+	t.Run("acquires a lease for one owner at a time, until it expires", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		lease, err := db.AcquireLease(context.Background(), "leader", "a", 10*time.Millisecond)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+		require.Equal(t, "leader", lease.Name)
+		require.Equal(t, "a", lease.Owner)
+		require.Equal(t, 1, lease.Token)
+
+		lease, err = db.AcquireLease(context.Background(), "leader", "b", 10*time.Millisecond)
+		require.NoError(t, err)
+		require.Nil(t, lease)
+
+		lease, err = db.AcquireLease(context.Background(), "leader", "a", 10*time.Millisecond)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+		require.Equal(t, 1, lease.Token)
+
+		time.Sleep(20 * time.Millisecond)
+
+		lease, err = db.AcquireLease(context.Background(), "leader", "b", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+		require.Equal(t, "b", lease.Owner)
+		require.Equal(t, 2, lease.Token)
+	})
+
+	t.Run("leases with different names are independent", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		lease, err := db.AcquireLease(context.Background(), "leader", "a", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+
+		lease, err = db.AcquireLease(context.Background(), "other", "b", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+	})
+}
+
+func TestDatabase_ReleaseLease(t *testing.T) {
+	t.Run("lets another owner acquire the lease right away, with a new token", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		_, err := db.AcquireLease(context.Background(), "leader", "a", time.Minute)
+		require.NoError(t, err)
+
+		err = db.ReleaseLease(context.Background(), "leader", "b")
+		require.NoError(t, err)
+
+		lease, err := db.AcquireLease(context.Background(), "leader", "b", time.Minute)
+		require.NoError(t, err)
+		require.Nil(t, lease)
+
+		err = db.ReleaseLease(context.Background(), "leader", "a")
+		require.NoError(t, err)
+
+		lease, err = db.AcquireLease(context.Background(), "leader", "b", time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, lease)
+		require.Equal(t, 2, lease.Token)
+	})
+}
+
+func TestDatabase_Lead(t *testing.T) {
+	t.Run("runs only one leader at a time, and hands over when the leader stops", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		var lock sync.Mutex
+		var leaders []string
+		lead := func(owner string) func(ctx context.Context) {
+			return func(ctx context.Context) {
+				lock.Lock()
+				leaders = append(leaders, owner)
+				lock.Unlock()
+				<-ctx.Done()
+			}
+		}
+
+		ctxA, cancelA := context.WithCancel(context.Background())
+		ctxB, cancelB := context.WithCancel(context.Background())
+		defer cancelB()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			db.Lead(ctxA, "leader", "a", 30*time.Millisecond, lead("a"))
+		}()
+
+		require.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(leaders) == 1
+		}, time.Second, time.Millisecond)
+
+		go func() {
+			defer wg.Done()
+			db.Lead(ctxB, "leader", "b", 30*time.Millisecond, lead("b"))
+		}()
+
+		// b doesn't take over while a keeps renewing the lease
+		time.Sleep(100 * time.Millisecond)
+		lock.Lock()
+		require.Equal(t, []string{"a"}, leaders)
+		lock.Unlock()
+
+		cancelA()
+
+		require.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(leaders) == 2
+		}, time.Second, time.Millisecond)
+
+		cancelB()
+		wg.Wait()
+
+		require.Equal(t, []string{"a", "b"}, leaders)
+	})
+}
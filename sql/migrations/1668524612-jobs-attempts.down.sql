@@ -0,0 +1,2 @@
+alter table jobs drop column max_attempts;
+alter table jobs drop column attempts;
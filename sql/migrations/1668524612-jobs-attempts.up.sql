@@ -0,0 +1,2 @@
+alter table jobs add column attempts int not null default 0;
+alter table jobs add column max_attempts int not null default 3;
@@ -0,0 +1 @@
+drop table jobs_failed;
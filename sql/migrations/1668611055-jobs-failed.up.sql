@@ -0,0 +1,14 @@
+create table jobs_failed (
+  id integer primary key,
+  name text not null,
+  payload text not null,
+  timeout int not null,
+  attempts int not null,
+  max_attempts int not null,
+  error text not null,
+  created text not null,
+  failed text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+create index jobs_failed_name_idx on jobs_failed (name);
+create index jobs_failed_failed_idx on jobs_failed (failed);
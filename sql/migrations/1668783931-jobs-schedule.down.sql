@@ -0,0 +1,3 @@
+drop index jobs_name_schedule_idx;
+
+alter table jobs drop column schedule;
@@ -0,0 +1,3 @@
+alter table jobs add column schedule text not null default '';
+
+create unique index jobs_name_schedule_idx on jobs (name) where schedule != '';
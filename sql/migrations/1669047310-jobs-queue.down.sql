@@ -0,0 +1,5 @@
+drop index jobs_queue_created_idx;
+create index jobs_created_idx on jobs (created);
+
+alter table jobs_failed drop column queue;
+alter table jobs drop column queue;
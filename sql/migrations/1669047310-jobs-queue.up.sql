@@ -0,0 +1,5 @@
+alter table jobs add column queue text not null default 'default';
+alter table jobs_failed add column queue text not null default 'default';
+
+drop index jobs_created_idx;
+create index jobs_queue_created_idx on jobs (queue, created);
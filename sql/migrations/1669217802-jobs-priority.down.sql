@@ -0,0 +1,5 @@
+drop index jobs_queue_priority_created_idx;
+create index jobs_queue_created_idx on jobs (queue, created);
+
+alter table jobs_failed drop column priority;
+alter table jobs drop column priority;
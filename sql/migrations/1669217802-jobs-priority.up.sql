@@ -0,0 +1,5 @@
+alter table jobs add column priority int not null default 0;
+alter table jobs_failed add column priority int not null default 0;
+
+drop index jobs_queue_created_idx;
+create index jobs_queue_priority_created_idx on jobs (queue, priority desc, created);
@@ -0,0 +1,3 @@
+drop index jobs_key_idx;
+
+alter table jobs drop column key;
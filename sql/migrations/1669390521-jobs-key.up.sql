@@ -0,0 +1,3 @@
+alter table jobs add column key text not null default '';
+
+create unique index jobs_key_idx on jobs (key) where key != '';
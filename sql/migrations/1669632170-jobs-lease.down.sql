@@ -0,0 +1,2 @@
+alter table jobs_failed drop column lease;
+alter table jobs drop column lease;
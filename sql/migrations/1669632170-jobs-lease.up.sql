@@ -0,0 +1,2 @@
+alter table jobs add column lease int not null default 0;
+alter table jobs_failed add column lease int not null default 0;
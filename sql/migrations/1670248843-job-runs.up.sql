@@ -0,0 +1,16 @@
+create table job_runs (
+  id integer primary key,
+  job_id int not null,
+  name text not null,
+  queue text not null,
+  payload text not null,
+  attempt int not null,
+  started text not null,
+  ended text not null,
+  outcome text not null check (outcome in ('success', 'retry', 'failure')),
+  error text not null default '',
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+create index job_runs_name_started_idx on job_runs (name, started);
+create index job_runs_started_idx on job_runs (started);
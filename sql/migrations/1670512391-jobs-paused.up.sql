@@ -0,0 +1,4 @@
+create table jobs_paused (
+  name text primary key,
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
@@ -0,0 +1,32 @@
+drop table job_dependencies;
+
+create table jobs_old (
+  id integer primary key,
+  name text not null,
+  payload text not null,
+  timeout int not null,
+  run text not null default (strftime('%Y-%m-%dT%H:%M:%fZ')),
+  received text,
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ')),
+  updated text not null default (strftime('%Y-%m-%dT%H:%M:%fZ')),
+  attempts int not null default 0,
+  max_attempts int not null default 3,
+  schedule text not null default '',
+  queue text not null default 'default',
+  priority int not null default 0,
+  key text not null default '',
+  lease int not null default 0
+) strict;
+
+insert into jobs_old select * from jobs;
+drop table jobs;
+alter table jobs_old rename to jobs;
+
+create trigger jobs_updated_timestamp after update on jobs begin
+  update jobs set updated = strftime('%Y-%m-%dT%H:%M:%fZ') where id = old.id;
+end;
+
+create index jobs_run_idx on jobs (run);
+create unique index jobs_name_schedule_idx on jobs (name) where schedule != '';
+create index jobs_queue_priority_created_idx on jobs (queue, priority desc, created);
+create unique index jobs_key_idx on jobs (key) where key != '';
@@ -0,0 +1,7 @@
+drop index jobs_failed_batch_idx;
+drop index jobs_batch_idx;
+
+alter table jobs_failed drop column batch;
+alter table jobs drop column batch;
+
+drop table job_batches;
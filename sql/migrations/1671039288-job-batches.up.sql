@@ -0,0 +1,11 @@
+create table job_batches (
+  id text primary key,
+  total int not null default 0,
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+alter table jobs add column batch text not null default '';
+alter table jobs_failed add column batch text not null default '';
+
+create index jobs_batch_idx on jobs (batch) where batch != '';
+create index jobs_failed_batch_idx on jobs_failed (batch) where batch != '';
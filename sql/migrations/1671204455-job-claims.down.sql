@@ -0,0 +1,2 @@
+drop trigger jobs_claimed;
+drop table job_claims;
@@ -0,0 +1,12 @@
+-- Every time a job is received, a claim is recorded, so the number of runs per name can be rate limited
+create table job_claims (
+  name text not null,
+  claimed text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+create index job_claims_name_claimed_idx on job_claims (name, claimed);
+
+create trigger jobs_claimed after update of attempts on jobs when new.attempts > old.attempts begin
+  insert into job_claims (name) values (new.name);
+  delete from job_claims where name = new.name and claimed < strftime('%Y-%m-%dT%H:%M:%fZ', 'now', '-1 day');
+end;
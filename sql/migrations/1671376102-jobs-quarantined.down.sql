@@ -0,0 +1 @@
+alter table jobs_failed drop column quarantined;
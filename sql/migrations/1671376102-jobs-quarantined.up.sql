@@ -0,0 +1 @@
+alter table jobs_failed add column quarantined int not null default 0;
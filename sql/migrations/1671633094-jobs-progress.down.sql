@@ -0,0 +1,6 @@
+drop trigger job_results_created;
+drop table job_results;
+
+alter table jobs drop column result;
+alter table jobs drop column progress_message;
+alter table jobs drop column progress;
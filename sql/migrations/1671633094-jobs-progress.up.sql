@@ -0,0 +1,20 @@
+alter table jobs add column progress int not null default 0;
+alter table jobs add column progress_message text not null default '';
+alter table jobs add column result text;
+
+-- Jobs that reported progress or a result keep their final status after finishing, so it can still be polled
+create table job_results (
+  job_id integer primary key,
+  name text not null,
+  progress int not null,
+  progress_message text not null,
+  result text,
+  error text not null default '',
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+create index job_results_created_idx on job_results (created);
+
+create trigger job_results_created after insert on job_results begin
+  delete from job_results where created < strftime('%Y-%m-%dT%H:%M:%fZ', 'now', '-7 days');
+end;
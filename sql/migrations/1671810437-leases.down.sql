@@ -0,0 +1,2 @@
+drop trigger leases_updated_timestamp;
+drop table leases;
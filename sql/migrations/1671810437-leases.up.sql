@@ -0,0 +1,13 @@
+-- A lease is held by one owner at a time until it expires. The token increases every time the owner changes.
+create table leases (
+  name text primary key,
+  owner text not null,
+  expires text not null,
+  token int not null,
+  created text not null default (strftime('%Y-%m-%dT%H:%M:%fZ')),
+  updated text not null default (strftime('%Y-%m-%dT%H:%M:%fZ'))
+) strict;
+
+create trigger leases_updated_timestamp after update on leases begin
+  update leases set updated = strftime('%Y-%m-%dT%H:%M:%fZ') where name = old.name;
+end;
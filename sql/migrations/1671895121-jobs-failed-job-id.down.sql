@@ -0,0 +1,3 @@
+drop index jobs_failed_job_id_idx;
+
+alter table jobs_failed drop column job_id;
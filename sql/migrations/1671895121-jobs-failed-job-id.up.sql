@@ -0,0 +1,4 @@
+-- The ID the job had before it failed, so jobs depending on it can be told apart from jobs depending on finished ones
+alter table jobs_failed add column job_id int not null default 0;
+
+create index jobs_failed_job_id_idx on jobs_failed (job_id) where job_id != 0;
@@ -0,0 +1,3 @@
+drop index jobs_name_key_idx;
+
+create unique index jobs_key_idx on jobs (key) where key != '';
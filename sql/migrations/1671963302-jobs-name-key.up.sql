@@ -0,0 +1,4 @@
+-- Keys are unique per name, and only among pending jobs, so a new job can be created while one with the same key runs
+drop index jobs_key_idx;
+
+create unique index jobs_name_key_idx on jobs (name, key) where key != '' and received is null;
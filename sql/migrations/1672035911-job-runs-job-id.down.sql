@@ -0,0 +1 @@
+drop index job_runs_job_id_started_idx;
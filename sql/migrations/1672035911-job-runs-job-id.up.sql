@@ -0,0 +1 @@
+create index job_runs_job_id_started_idx on job_runs (job_id, started);
@@ -0,0 +1,6 @@
+alter table job_claims drop column job_id;
+
+create trigger jobs_claimed after update of attempts on jobs when new.attempts > old.attempts begin
+  insert into job_claims (name) values (new.name);
+  delete from job_claims where name = new.name and claimed < strftime('%Y-%m-%dT%H:%M:%fZ', 'now', '-1 day');
+end;
@@ -0,0 +1,5 @@
+-- Claims are only recorded for jobs with a rate limit when they're received, and removed again when they're released,
+-- so the trigger recording a claim for every received job is replaced by GetJob and ReleaseJob
+drop trigger jobs_claimed;
+
+alter table job_claims add column job_id int not null default 0;
@@ -0,0 +1,63 @@
+package sql
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maragudk/errors"
+)
+
+// primaryCacheDuration is how long the result of reading the LiteFS primary file is reused.
+// IsPrimary is called for every poll of every job queue and every non-GET request, and the primary rarely changes.
+const primaryCacheDuration = time.Second
+
+// primaryCache of the last successful check in IsPrimary.
+type primaryCache struct {
+	checked  time.Time
+	hostname string
+	lock     sync.Mutex
+	primary  bool
+}
+
+// IsPrimary returns whether this instance is the primary, which is the only one that can write to the database.
+// If it's not, the hostname of the primary is returned as well.
+// LiteFS keeps a .primary file with the hostname of the primary in its directory on replicas only.
+// Without a LiteFS directory, there are no replicas, so this instance is always the primary.
+// The result is cached for a second, so a change of primary is noticed with a delay of up to that.
+func (d *Database) IsPrimary() (bool, string, error) {
+	if d.liteFSDir == "" {
+		return true, "", nil
+	}
+
+	d.primary.lock.Lock()
+	defer d.primary.lock.Unlock()
+
+	if time.Since(d.primary.checked) < primaryCacheDuration {
+		return d.primary.primary, d.primary.hostname, nil
+	}
+
+	primary, hostname := true, ""
+	data, err := os.ReadFile(filepath.Join(d.liteFSDir, ".primary"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, "", errors.Wrap(err, "error reading LiteFS primary file")
+	}
+	if err == nil {
+		primary, hostname = false, strings.TrimSpace(string(data))
+	}
+
+	d.primary.checked = time.Now()
+	d.primary.primary = primary
+	d.primary.hostname = hostname
+	return primary, hostname, nil
+}
+
+// Ping the database by reading from it, which also works on replicas.
+func (d *Database) Ping(ctx context.Context) error {
+	var exists bool
+	return d.DB.GetContext(ctx, &exists, `select exists (select 1 from sqlite_master)`)
+}
@@ -0,0 +1,74 @@
+package sql_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/maragudk/service/sql"
+	"github.com/maragudk/service/sqltest"
+)
+
+func TestDatabase_IsPrimary(t *testing.T) {
+	t.Run("is always primary without a LiteFS directory", func(t *testing.T) {
+		db := sql.NewDatabase(sql.NewDatabaseOptions{})
+
+		primary, hostname, err := db.IsPrimary()
+		require.NoError(t, err)
+		require.True(t, primary)
+		require.Equal(t, "", hostname)
+	})
+
+	t.Run("is primary if there is no primary file in the LiteFS directory", func(t *testing.T) {
+		db := sql.NewDatabase(sql.NewDatabaseOptions{LiteFSDir: t.TempDir()})
+
+		primary, _, err := db.IsPrimary()
+		require.NoError(t, err)
+		require.True(t, primary)
+	})
+
+	t.Run("is not primary if there is a primary file, and returns the primary hostname", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, ".primary"), []byte("machine-1\n"), 0644)
+		require.NoError(t, err)
+
+		db := sql.NewDatabase(sql.NewDatabaseOptions{LiteFSDir: dir})
+
+		primary, hostname, err := db.IsPrimary()
+		require.NoError(t, err)
+		require.False(t, primary)
+		require.Equal(t, "machine-1", hostname)
+	})
+
+	t.Run("caches the result for a while", func(t *testing.T) {
+		dir := t.TempDir()
+		err := os.WriteFile(filepath.Join(dir, ".primary"), []byte("machine-1\n"), 0644)
+		require.NoError(t, err)
+
+		db := sql.NewDatabase(sql.NewDatabaseOptions{LiteFSDir: dir})
+
+		primary, _, err := db.IsPrimary()
+		require.NoError(t, err)
+		require.False(t, primary)
+
+		err = os.Remove(filepath.Join(dir, ".primary"))
+		require.NoError(t, err)
+
+		primary, hostname, err := db.IsPrimary()
+		require.NoError(t, err)
+		require.False(t, primary)
+		require.Equal(t, "machine-1", hostname)
+	})
+}
+
+func TestDatabase_Ping(t *testing.T) {
+	t.Run("reads from the database", func(t *testing.T) {
+		db := sqltest.CreateDatabase(t)
+
+		err := db.Ping(context.Background())
+		require.NoError(t, err)
+	})
+}